@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+	"github.com/grokify/ringcentral-legacy-api-proxy/handlers"
+)
+
+// AdminRoutes returns the route table for the admin API, which is
+// served on `ADMIN_PORT` and requires the `ADMIN_TOKEN` bearer token.
+func (h *Handler) AdminRoutes() []Route {
+	routes := []Route{
+		{Name: "admin_tokens", Path: "/admin/tokens",
+			Methods: []string{http.MethodGet},
+			Handler: h.adminHandler(h.handleAnyRequestAdminTokens)},
+		{Name: "admin_tokens_evict", Path: "/admin/tokens/evict",
+			Methods: []string{http.MethodPost},
+			Handler: h.adminHandler(h.handleAnyRequestAdminTokenEvict)},
+		{Name: "admin_sessions", Path: "/admin/sessions",
+			Methods: []string{http.MethodGet},
+			Handler: h.adminHandler(h.handleAnyRequestAdminSessions)},
+		{Name: "admin_sessions_cancel", Path: "/admin/sessions/cancel",
+			Methods: []string{http.MethodPost},
+			Handler: h.adminHandler(h.handleAnyRequestAdminSessionCancel)},
+	}
+	if h.APIKeys != nil {
+		routes = append(routes,
+			Route{Name: "admin_apikeys", Path: "/admin/apikeys",
+				Methods: []string{http.MethodGet, http.MethodPost},
+				Handler: h.adminHandler(h.handleAnyRequestAdminAPIKeys)},
+			Route{Name: "admin_apikeys_revoke", Path: "/admin/apikeys/revoke",
+				Methods: []string{http.MethodPost},
+				Handler: h.adminHandler(h.handleAnyRequestAdminAPIKeyRevoke)})
+	}
+	return routes
+}
+
+// adminHandler returns a handler which writes a `401` for requests
+// without the admin token and parses the form for authorized requests.
+func (h *Handler) adminHandler(handler AnyHandlerFunc) AnyHandlerFunc {
+	return func(aRes anyhttp.Response, aReq anyhttp.Request) {
+		if !h.AdminAuth.Authorized(aReq) {
+			handlers.RequestLogFromContext(handlers.RequestContext(aReq)).Entry().WithFields(log.Fields{
+				"action": "admin_unauthorized"}).Warn("Admin request without a valid token.")
+			handlers.WriteUnauthorizedAdmin(aRes)
+			return
+		}
+		if err := aReq.ParseForm(); err != nil {
+			handlers.WriteErrorJson(aRes, http.StatusBadRequest, err)
+			return
+		}
+		handler(aRes, aReq)
+	}
+}
+
+// logAdminAction logs a change made with the admin API.
+func logAdminAction(aReq anyhttp.Request, action string, fields log.Fields) {
+	fields["action"] = action
+	handlers.RequestLogFromContext(handlers.RequestContext(aReq)).Entry().WithFields(fields).Info("ADMIN_ACTION")
+}
+
+// handleAnyRequestAdminAPIKeys lists API keys on `GET` and creates one
+// on `POST` with the `name`, `app`, `username`, `extension` and one of
+// `password`, `jwt` or `refreshToken` parameters. The key is only
+// returned when created.
+func (h *Handler) handleAnyRequestAdminAPIKeys(aRes anyhttp.Response, aReq anyhttp.Request) {
+	if string(aReq.Method()) == http.MethodGet {
+		infos := []handlers.APIKeyInfo{}
+		for _, apiKey := range h.APIKeys.List() {
+			infos = append(infos, handlers.NewAPIKeyInfo(apiKey))
+		}
+		handlers.WriteJson(aRes, http.StatusOK, infos)
+		return
+	}
+
+	args := handlers.NewLegacyArgs(aReq.AllArgs())
+	name := args.GetString("name")
+	if len(name) == 0 {
+		handlers.WriteErrorJson(aRes, http.StatusBadRequest, fmt.Errorf("name is required"))
+		return
+	}
+	app := args.GetString("app")
+	if len(app) > 0 {
+		if _, ok := h.Apps.Get(app); !ok {
+			handlers.WriteErrorJson(aRes, http.StatusBadRequest, fmt.Errorf("app [%v] is not configured", app))
+			return
+		}
+	}
+	creds := handlers.NewStoredCredentials(
+		args.GetString("username"), args.GetString("extension"), args.GetString("password"),
+		args.GetString("jwt"), args.GetString("refreshToken"))
+	if err := creds.Validate(); err != nil {
+		handlers.WriteErrorJson(aRes, http.StatusBadRequest, err)
+		return
+	}
+	key, apiKey, err := h.APIKeys.Create(name, app, creds)
+	if err != nil {
+		handlers.WriteErrorJson(aRes, http.StatusInternalServerError, err)
+		return
+	}
+	logAdminAction(aReq, "admin_apikey_create", log.Fields{"apiKeyId": apiKey.ID, "name": apiKey.Name})
+	info := handlers.NewAPIKeyInfo(apiKey)
+	info.Key = key
+	handlers.WriteJson(aRes, http.StatusCreated, info)
+}
+
+// handleAnyRequestAdminAPIKeyRevoke revokes the API key `id`, evicts
+// its cached token and deletes the RingOut sessions created with it.
+func (h *Handler) handleAnyRequestAdminAPIKeyRevoke(aRes anyhttp.Response, aReq anyhttp.Request) {
+	id := handlers.NewLegacyArgs(aReq.AllArgs()).GetString("id")
+	if err := h.APIKeys.Revoke(id); err != nil {
+		handlers.WriteErrorJson(aRes, http.StatusNotFound, err)
+		return
+	}
+	evicted := h.TokenCache.EvictUser("apikey:" + id)
+	deleted := h.Sessions.DeleteAPIKey(id)
+	logAdminAction(aReq, "admin_apikey_revoke", log.Fields{
+		"apiKeyId": id, "tokensEvicted": evicted, "sessionsDeleted": deleted})
+	anyhttp.WriteSimpleJson(aRes, http.StatusOK, fmt.Sprintf("Revoked API key [%v]", id))
+}
+
+// handleAnyRequestAdminTokens lists cached tokens with masked users,
+// optionally only those for the `user` parameter.
+func (h *Handler) handleAnyRequestAdminTokens(aRes anyhttp.Response, aReq anyhttp.Request) {
+	user := handlers.NewLegacyArgs(aReq.AllArgs()).GetString("user")
+	handlers.WriteJson(aRes, http.StatusOK, h.TokenCache.Tokens(user))
+}
+
+// handleAnyRequestAdminTokenEvict evicts the cached token `id`, so the
+// next request for the user gets a new token.
+func (h *Handler) handleAnyRequestAdminTokenEvict(aRes anyhttp.Response, aReq anyhttp.Request) {
+	id := handlers.NewLegacyArgs(aReq.AllArgs()).GetString("id")
+	if !h.TokenCache.EvictID(id) {
+		handlers.WriteErrorJson(aRes, http.StatusNotFound, fmt.Errorf("token [%v] not found", id))
+		return
+	}
+	logAdminAction(aReq, "admin_token_evict", log.Fields{"tokenId": id})
+	anyhttp.WriteSimpleJson(aRes, http.StatusOK, fmt.Sprintf("Evicted token [%v]", id))
+}
+
+// handleAnyRequestAdminSessions lists active RingOut sessions.
+func (h *Handler) handleAnyRequestAdminSessions(aRes anyhttp.Response, aReq anyhttp.Request) {
+	handlers.WriteJson(aRes, http.StatusOK, h.Sessions.List())
+}
+
+// handleAnyRequestAdminSessionCancel cancels the RingOut call for the
+// session `id` and deletes the session.
+func (h *Handler) handleAnyRequestAdminSessionCancel(aRes anyhttp.Response, aReq anyhttp.Request) {
+	id := handlers.NewLegacyArgs(aReq.AllArgs()).GetString("id")
+	ctx, cancel := h.Timeouts.WithTimeout(aReq, "cancel")
+	defer cancel()
+	found, err := h.Sessions.Cancel(ctx, id)
+	if !found {
+		handlers.WriteErrorJson(aRes, http.StatusNotFound, fmt.Errorf("session [%v] not found", id))
+		return
+	} else if err != nil {
+		statusCode := http.StatusBadGateway
+		if handlers.IsTimeout(ctx) {
+			statusCode = http.StatusGatewayTimeout
+		}
+		handlers.WriteErrorJson(aRes, statusCode, err)
+		return
+	}
+	logAdminAction(aReq, "admin_session_cancel", log.Fields{"sessionId": id})
+	anyhttp.WriteSimpleJson(aRes, http.StatusOK, fmt.Sprintf("Cancelled session [%v]", id))
+}
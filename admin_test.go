@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grokify/ringcentral-legacy-api-proxy/handlers"
+)
+
+const testAdminToken = "0123456789abcdef-admin"
+
+func newAdminTestHandler(t *testing.T) *Handler {
+	config := newAPIKeyTestConfig(t)
+	cache, err := handlers.NewTokenCache(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Handler{
+		Apps:       config.Apps,
+		APIKeys:    config.APIKeys,
+		Sessions:   handlers.NewSessionStore(time.Hour),
+		TokenCache: cache,
+		Timeouts:   handlers.NewUpstreamTimeouts(),
+		AdminAuth:  handlers.AdminAuth{Token: testAdminToken}}
+}
+
+// adminRequest sends an admin API request with the admin token and
+// decodes the JSON response into `v` if it is not nil.
+func adminRequest(t *testing.T, router *Router, method, path string, form url.Values, v interface{}) int {
+	req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Errorf("%v %v: invalid JSON [%v]", method, path, rec.Body.String())
+		}
+	}
+	return rec.Code
+}
+
+func TestAdminAPIKeys(t *testing.T) {
+	h := newAdminTestHandler(t)
+	router := NewRouter("nethttp", h.AdminRoutes()...)
+
+	tests := []struct {
+		form       url.Values
+		statusCode int
+	}{
+		{url.Values{"name": {"front desk"}, "username": {"+16505550100"}, "password": {"p@ssw0rd!"}}, http.StatusCreated},
+		{url.Values{"name": {"sandbox"}, "app": {"sandbox"}, "jwt": {"eyJ.jwt"}}, http.StatusCreated},
+		{url.Values{"username": {"+16505550100"}, "password": {"p"}}, http.StatusBadRequest},
+		{url.Values{"name": {"x"}, "app": {"missing"}, "jwt": {"j"}}, http.StatusBadRequest},
+		{url.Values{"name": {"x"}, "username": {"+16505550100"}}, http.StatusBadRequest},
+	}
+	created := []handlers.APIKeyInfo{}
+	for _, tt := range tests {
+		info := handlers.APIKeyInfo{}
+		if got := adminRequest(t, router, http.MethodPost, "/admin/apikeys", tt.form, &info); got != tt.statusCode {
+			t.Errorf("POST /admin/apikeys %v: want [%v], got [%v]", tt.form, tt.statusCode, got)
+		} else if got == http.StatusCreated {
+			if _, ok := h.APIKeys.Lookup(info.Key); !ok {
+				t.Errorf("POST /admin/apikeys %v: created key [%v] not found", tt.form, info.Key)
+			}
+			created = append(created, info)
+		}
+	}
+
+	infos := []handlers.APIKeyInfo{}
+	if got := adminRequest(t, router, http.MethodGet, "/admin/apikeys", nil, &infos); got != http.StatusOK || len(infos) != 2 {
+		t.Fatalf("GET /admin/apikeys: want [200] with [2] keys, got [%v] %v", got, infos)
+	}
+	for _, info := range infos {
+		if len(info.Key) > 0 {
+			t.Errorf("GET /admin/apikeys: key [%v] listed", info.ID)
+		}
+	}
+
+	// Revoking a key deletes its RingOut sessions.
+	apiKeyID := created[0].ID
+	if _, err := h.Sessions.Create(nil, apiKeyID, "4567", "+16505550101", "+16505550100"); err != nil {
+		t.Fatal(err)
+	}
+	other, err := h.Sessions.Create(nil, created[1].ID, "4568", "+16505550101", "+16505550100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoke := url.Values{"id": {apiKeyID}}
+	if got := adminRequest(t, router, http.MethodPost, "/admin/apikeys/revoke", revoke, nil); got != http.StatusOK {
+		t.Errorf("POST /admin/apikeys/revoke: want [200], got [%v]", got)
+	}
+	if got := adminRequest(t, router, http.MethodPost, "/admin/apikeys/revoke", revoke, nil); got != http.StatusNotFound {
+		t.Errorf("POST /admin/apikeys/revoke twice: want [404], got [%v]", got)
+	}
+	sessions := []handlers.SessionInfo{}
+	adminRequest(t, router, http.MethodGet, "/admin/sessions", nil, &sessions)
+	if len(sessions) != 1 || sessions[0].ID != other.PublicID() || sessions[0].APIKeyID != created[1].ID {
+		t.Errorf("GET /admin/sessions after revoke: want session [%v], got %v", other.PublicID(), sessions)
+	}
+}
+
+func TestAdminAuthorization(t *testing.T) {
+	h := newAdminTestHandler(t)
+	router := NewRouter("nethttp", h.AdminRoutes()...)
+	tests := []struct {
+		method string
+		path   string
+		header string
+		want   int
+	}{
+		{http.MethodGet, "/admin/tokens", "", http.StatusUnauthorized},
+		{http.MethodGet, "/admin/tokens", "Bearer wrong-token-0123456789", http.StatusUnauthorized},
+		{http.MethodPost, "/admin/apikeys/revoke?id=abc", "", http.StatusUnauthorized},
+		{http.MethodGet, "/admin/tokens?user=16505550100", "Bearer " + testAdminToken, http.StatusOK},
+		{http.MethodPost, "/admin/tokens/evict?id=missing", "Bearer " + testAdminToken, http.StatusNotFound},
+		{http.MethodPost, "/admin/sessions/cancel?id=missing", "Bearer " + testAdminToken, http.StatusNotFound},
+		{http.MethodGet, "/admin/tokens/evict", "Bearer " + testAdminToken, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if len(tt.header) > 0 {
+			req.Header.Set("Authorization", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%v %v with [%v]: want [%v], got [%v] %v", tt.method, tt.path, tt.header, tt.want, rec.Code, rec.Body.String())
+		}
+	}
+
+	// The API key routes are only served with an API key store.
+	h.APIKeys = nil
+	for _, route := range h.AdminRoutes() {
+		if strings.HasPrefix(route.Path, "/admin/apikeys") {
+			t.Errorf("AdminRoutes without API_KEY_STORE: got route [%v]", route.Path)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/grokify/ringcentral-legacy-api-proxy/handlers"
+)
+
+const apiKeyUsage = `usage: ringcentral-legacy-api-proxy apikey <command>
+
+commands:
+  create -name <name> [-app <app>] -username <username> [-extension <ext>] -password <password>
+  create -name <name> [-app <app>] -jwt <jwt>
+  create -name <name> [-app <app>] -refresh-token <token>
+  list
+  revoke <id>
+
+Set a credential to - to read it from stdin.`
+
+// runAPIKeyCommand creates, lists and revokes API keys in the
+// `API_KEY_STORE` store.
+func runAPIKeyCommand(config Config, args []string, stdin io.Reader, stdout io.Writer) error {
+	if config.APIKeys == nil {
+		if err := config.Validate(); err != nil {
+			return err
+		}
+		return fmt.Errorf("API_KEY_STORE is not set")
+	}
+	if len(args) == 0 {
+		return fmt.Errorf(apiKeyUsage)
+	}
+	switch args[0] {
+	case "create":
+		return createAPIKey(config, args[1:], stdin, stdout)
+	case "list":
+		w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tAPP\tGRANT\tUSERNAME\tCREATED")
+		for _, apiKey := range config.APIKeys.List() {
+			fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\n",
+				apiKey.ID, apiKey.Name, apiKey.App, apiKey.Credentials.GrantType,
+				handlers.MaskPhoneNumber(apiKey.Credentials.Username),
+				apiKey.Created.Format("2006-01-02T15:04:05Z"))
+		}
+		return w.Flush()
+	case "revoke":
+		if len(args) != 2 {
+			return fmt.Errorf(apiKeyUsage)
+		}
+		return config.APIKeys.Revoke(args[1])
+	}
+	return fmt.Errorf(apiKeyUsage)
+}
+
+func createAPIKey(config Config, args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+	name := flags.String("name", "", "API key name, e.g. the client or user")
+	app := flags.String("app", "", "app name, or empty to select the app per request")
+	username := flags.String("username", "", "RingCentral username for the password grant")
+	extension := flags.String("extension", "", "RingCentral extension for the password grant")
+	password := flags.String("password", "", "RingCentral password for the password grant")
+	jwt := flags.String("jwt", "", "RingCentral JWT for the JWT grant")
+	refreshToken := flags.String("refresh-token", "", "RingCentral refresh token")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if len(strings.TrimSpace(*name)) == 0 {
+		return fmt.Errorf("-name is required")
+	}
+	if len(*app) > 0 {
+		if _, ok := config.Apps.Get(*app); !ok {
+			return fmt.Errorf("app [%v] is not configured", *app)
+		}
+	}
+
+	// A credential of `-` is read from stdin.
+	reader := bufio.NewReader(stdin)
+	for _, secret := range []*string{password, jwt, refreshToken} {
+		if *secret == "-" {
+			line, err := reader.ReadString('\n')
+			if err != nil && err != io.EOF {
+				return err
+			}
+			*secret = strings.TrimSpace(line)
+		}
+	}
+	creds := handlers.NewStoredCredentials(*username, *extension, *password, *jwt, *refreshToken)
+
+	key, apiKey, err := config.APIKeys.Create(*name, *app, creds)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Created API key [%v]. It is only shown once:\n%v\n", apiKey.ID, key)
+	return nil
+}
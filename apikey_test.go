@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/grokify/ringcentral-legacy-api-proxy/handlers"
+)
+
+func newAPIKeyTestConfig(t *testing.T) Config {
+	store, err := handlers.NewAPIKeyStore(filepath.Join(t.TempDir(), "apikeys.enc"), "0123456789abcdef-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps := handlers.NewAppRegistry()
+	apps.Add(&handlers.App{Name: handlers.DefaultAppName})
+	apps.Add(&handlers.App{Name: "sandbox"})
+	return Config{Apps: apps, APIKeys: store}
+}
+
+func TestRunAPIKeyCommand(t *testing.T) {
+	config := newAPIKeyTestConfig(t)
+	tests := []struct {
+		args    []string
+		stdin   string
+		want    string
+		wantErr string
+	}{
+		{[]string{"create", "-name", "front desk", "-username", "+16505550100", "-password", "-"},
+			"p@ssw0rd!\n", "Created API key [", ""},
+		{[]string{"create", "-name", "sandbox", "-app", "Sandbox", "-jwt", "eyJ.jwt"}, "", "Created API key [", ""},
+		{[]string{"create", "-name", "refresh", "-refresh-token", "-"}, "rt", "Created API key [", ""},
+		{[]string{"create", "-username", "+16505550100", "-password", "p"}, "", "", "-name is required"},
+		{[]string{"create", "-name", "x", "-app", "missing", "-jwt", "j"}, "", "", "app [missing] is not configured"},
+		{[]string{"create", "-name", "x", "-username", "+16505550100"}, "", "", "username and password are required"},
+		{[]string{"create", "-unknown"}, "", "", "flag provided but not defined"},
+		{[]string{"revoke"}, "", "", "usage:"},
+		{[]string{"revoke", "missing"}, "", "", "missing"},
+		{[]string{"rotate"}, "", "", "usage:"},
+		{nil, "", "", "usage:"},
+	}
+	for _, tt := range tests {
+		stdout := &bytes.Buffer{}
+		err := runAPIKeyCommand(config, tt.args, strings.NewReader(tt.stdin), stdout)
+		if len(tt.wantErr) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("apikey %v: want error [%v], got [%v]", tt.args, tt.wantErr, err)
+			}
+			continue
+		}
+		if err != nil || !strings.Contains(stdout.String(), tt.want) {
+			t.Errorf("apikey %v: want [%v], got [%v] %v", tt.args, tt.want, stdout.String(), err)
+		}
+		// The key is only printed once and is accepted by the store.
+		lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+		if _, ok := config.APIKeys.Lookup(lines[len(lines)-1]); !ok {
+			t.Errorf("apikey %v: created key [%v] not found", tt.args, lines[len(lines)-1])
+		}
+	}
+
+	keys := config.APIKeys.List()
+	if len(keys) != 3 {
+		t.Fatalf("apikey create: want [3] keys, got [%v]", len(keys))
+	}
+	stdout := &bytes.Buffer{}
+	if err := runAPIKeyCommand(config, []string{"list"}, nil, stdout); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"ID", keys[0].ID, "front desk", "sandbox", "*******0100"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("apikey list: want [%v] in [%v]", want, stdout.String())
+		}
+	}
+	if strings.Contains(stdout.String(), "p@ssw0rd!") || strings.Contains(stdout.String(), "6505550100") {
+		t.Errorf("apikey list: credentials not masked in [%v]", stdout.String())
+	}
+
+	if err := runAPIKeyCommand(config, []string{"revoke", keys[0].ID}, nil, stdout); err != nil {
+		t.Errorf("apikey revoke: %v", err)
+	}
+	if got := len(config.APIKeys.List()); got != 2 {
+		t.Errorf("apikey revoke: want [2] keys, got [%v]", got)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	cfg "github.com/grokify/gotilla/config"
+	"gopkg.in/yaml.v2"
+
+	"github.com/grokify/ringcentral-legacy-api-proxy/handlers"
+)
+
+const DefaultPort = 3000
+
+// Config is the proxy configuration. Settings use environment variable
+// names and are loaded in order of precedence from:
+//
+//  1. environment variables
+//  2. the `.env` file, or the `ENV_PATH` file
+//  3. the `CONFIG_FILE` JSON or YAML file, a flat object of setting names
+//     to string, number or boolean values
+//  4. defaults
+type Config struct {
+	Port                         int
+	HTTPEngine                   string
+	Apps                         *handlers.AppRegistry
+	APIKeys                      *handlers.APIKeyStore
+	MetricsPort                  int
+	AdminPort                    int
+	AdminToken                   string
+	LogMaskPhoneNumbers          bool
+	ShutdownGracePeriod          time.Duration
+	ReadyzProbeUpstream          bool
+	ReadyzProbeInterval          time.Duration
+	IdempotencyWindow            time.Duration
+	IdempotencyFingerprint       bool
+	IdempotencyFingerprintWindow time.Duration
+	SMTP                         handlers.SMTPMailer
+	UpstreamTimeouts             handlers.UpstreamTimeouts
+	RateLimiter                  *handlers.RateLimiter
+	problems                     []string
+}
+
+// LoadConfig loads the configuration. Invalid values are reported
+// by `Validate`.
+func LoadConfig(configFile string) Config {
+	loader := &configLoader{}
+	if err := cfg.LoadDotEnvSkipEmpty(os.Getenv("ENV_PATH"), "./.env"); err != nil {
+		loader.problem("ENV_PATH: %v", err)
+	}
+	if len(configFile) > 0 {
+		if err := loadConfigFile(configFile); err != nil {
+			loader.problem("CONFIG_FILE: %v", err)
+		}
+	}
+
+	config := Config{
+		Port:                   loader.intValue("PORT", DefaultPort),
+		HTTPEngine:             strings.ToLower(loader.stringValue("HTTP_ENGINE", "nethttp")),
+		MetricsPort:            loader.intValue("METRICS_PORT", 0),
+		AdminPort:              loader.intValue("ADMIN_PORT", 0),
+		AdminToken:             loader.stringValue("ADMIN_TOKEN", ""),
+		LogMaskPhoneNumbers:    loader.boolValue("LOG_MASK_PHONE_NUMBERS", true),
+		ShutdownGracePeriod:    loader.durationValue("SHUTDOWN_GRACE_PERIOD", DefaultShutdownGracePeriod),
+		ReadyzProbeUpstream:    loader.boolValue("READYZ_PROBE_UPSTREAM", false),
+		ReadyzProbeInterval:    loader.durationValue("READYZ_PROBE_INTERVAL", handlers.DefaultUpstreamProbeInterval),
+		IdempotencyWindow:      loader.durationValue("IDEMPOTENCY_WINDOW", handlers.DefaultIdempotencyWindow),
+		IdempotencyFingerprint: loader.boolValue("IDEMPOTENCY_FINGERPRINT", true),
+		IdempotencyFingerprintWindow: loader.durationValue("IDEMPOTENCY_FINGERPRINT_WINDOW",
+			handlers.DefaultIdempotencyFingerprintWindow),
+		SMTP: handlers.SMTPMailer{
+			Addr:     loader.stringValue("SMTP_ADDR", ""),
+			Username: loader.stringValue("SMTP_USERNAME", ""),
+			Password: loader.stringValue("SMTP_PASSWORD", ""),
+			From:     loader.stringValue("SMTP_FROM", "")}}
+
+	var err error
+	if config.Apps, err = handlers.NewAppRegistryEnv(); err != nil {
+		loader.problem("%v", err)
+	}
+	if config.APIKeys, err = handlers.NewAPIKeyStoreEnv(); err != nil {
+		loader.problem("%v", err)
+	}
+	if config.UpstreamTimeouts, err = handlers.NewUpstreamTimeoutsEnv(); err != nil {
+		loader.problem("%v", err)
+	}
+	if config.RateLimiter, err = handlers.NewRateLimiterEnv(); err != nil {
+		loader.problem("%v", err)
+		config.RateLimiter = handlers.NewRateLimiter()
+	}
+	config.problems = loader.problems
+	return config
+}
+
+// loadConfigFile sets environment variables which are not already set
+// from a JSON file, or a YAML file if the extension is `.yaml` or `.yml`.
+func loadConfigFile(filename string) error {
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	settings := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(bytes, &settings)
+	default:
+		err = json.Unmarshal(bytes, &settings)
+	}
+	if err != nil {
+		return err
+	}
+	for name, val := range settings {
+		if _, ok := os.LookupEnv(name); ok {
+			continue
+		}
+		switch val.(type) {
+		case string, int, int64, uint64, float64, bool:
+			os.Setenv(name, fmt.Sprint(val))
+		default:
+			return fmt.Errorf("%v: value must be a string, number or boolean", name)
+		}
+	}
+	return nil
+}
+
+// Validate returns an error listing all invalid settings.
+func (config Config) Validate() error {
+	problems := append([]string{}, config.problems...)
+	if err := config.Apps.Validate(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	switch config.HTTPEngine {
+	case "nethttp", "fasthttp", "awslambda":
+	default:
+		problems = append(problems, fmt.Sprintf("HTTP_ENGINE: invalid engine [%v]", config.HTTPEngine))
+	}
+	if config.Port <= 0 || config.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("PORT: invalid port [%v]", config.Port))
+	}
+	if config.MetricsPort < 0 || config.MetricsPort > 65535 || (config.MetricsPort > 0 && config.MetricsPort == config.Port) {
+		problems = append(problems, fmt.Sprintf("METRICS_PORT: invalid port [%v]", config.MetricsPort))
+	}
+	if config.AdminPort < 0 || config.AdminPort > 65535 || (config.AdminPort > 0 &&
+		(config.AdminPort == config.Port || config.AdminPort == config.MetricsPort)) {
+		problems = append(problems, fmt.Sprintf("ADMIN_PORT: invalid port [%v]", config.AdminPort))
+	} else if config.AdminPort > 0 {
+		if len(config.AdminToken) < handlers.MinAdminTokenLength {
+			problems = append(problems, fmt.Sprintf("ADMIN_TOKEN: must be at least %v characters when ADMIN_PORT is set", handlers.MinAdminTokenLength))
+		}
+		if config.HTTPEngine == "awslambda" {
+			problems = append(problems, "ADMIN_PORT: not supported with HTTP_ENGINE awslambda")
+		}
+	}
+	if len(config.SMTP.Addr) > 0 && len(config.SMTP.From) == 0 {
+		problems = append(problems, "SMTP_FROM: required when SMTP_ADDR is set")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration: %v", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Write writes the effective configuration with secrets masked.
+func (config Config) Write(w io.Writer) {
+	settings := [][2]string{
+		{"PORT", strconv.Itoa(config.Port)},
+		{"HTTP_ENGINE", config.HTTPEngine},
+		{"RINGCENTRAL_APPS", strings.Join(config.Apps.Names(), ",")},
+		{"RINGCENTRAL_APP_DEFAULT", config.Apps.Default},
+		{"RINGCENTRAL_APP_HEADER", config.Apps.Header}}
+	for _, app := range config.Apps.Apps() {
+		settings = append(settings,
+			[2]string{app.EnvPrefix + "SERVER_URL", maskURL(app.Credentials.ServerURL)},
+			[2]string{app.EnvPrefix + "CLIENT_ID", app.Credentials.ClientID},
+			[2]string{app.EnvPrefix + "CLIENT_SECRET", maskSecret(app.Credentials.ClientSecret)})
+		if len(app.Hosts) > 0 {
+			settings = append(settings, [2]string{app.EnvPrefix + "HOSTS", strings.Join(app.Hosts, ",")})
+		}
+		if len(app.PathPrefix) > 0 {
+			settings = append(settings, [2]string{app.EnvPrefix + "PATH_PREFIX", app.PathPrefix})
+		}
+		if app.UsernamePattern != nil {
+			settings = append(settings, [2]string{app.EnvPrefix + "USERNAME_PATTERN", app.UsernamePattern.String()})
+		}
+	}
+	if config.APIKeys != nil {
+		settings = append(settings, [][2]string{
+			{"API_KEY_STORE", config.APIKeys.Path},
+			{"API_KEY_STORE_SECRET", maskSecret(os.Getenv("API_KEY_STORE_SECRET"))},
+			{"API_KEY_HEADER", config.APIKeys.Header},
+			{"API_KEYS_REQUIRED", strconv.FormatBool(config.APIKeys.Required)},
+			{"API_KEYS", strconv.Itoa(len(config.APIKeys.List()))}}...)
+	}
+	settings = append(settings, [][2]string{
+		{"METRICS_PORT", strconv.Itoa(config.MetricsPort)},
+		{"ADMIN_PORT", strconv.Itoa(config.AdminPort)},
+		{"ADMIN_TOKEN", maskSecret(config.AdminToken)},
+		{"LOG_MASK_PHONE_NUMBERS", strconv.FormatBool(config.LogMaskPhoneNumbers)},
+		{"SHUTDOWN_GRACE_PERIOD", config.ShutdownGracePeriod.String()},
+		{"READYZ_PROBE_UPSTREAM", strconv.FormatBool(config.ReadyzProbeUpstream)},
+		{"READYZ_PROBE_INTERVAL", config.ReadyzProbeInterval.String()},
+		{"IDEMPOTENCY_WINDOW", config.IdempotencyWindow.String()},
+		{"IDEMPOTENCY_FINGERPRINT", strconv.FormatBool(config.IdempotencyFingerprint)},
+		{"IDEMPOTENCY_FINGERPRINT_WINDOW", config.IdempotencyFingerprintWindow.String()},
+		{"SMTP_ADDR", config.SMTP.Addr},
+		{"SMTP_USERNAME", config.SMTP.Username},
+		{"SMTP_PASSWORD", maskSecret(config.SMTP.Password)},
+		{"SMTP_FROM", config.SMTP.From}}...)
+	for _, cmd := range sortedCommands(config.UpstreamTimeouts) {
+		settings = append(settings, [2]string{
+			"UPSTREAM_TIMEOUT_" + strings.ToUpper(cmd), config.UpstreamTimeouts.Get(cmd).String()})
+	}
+	if config.RateLimiter != nil {
+		settings = append(settings, [2]string{
+			"RATE_LIMIT_TRUST_FORWARDED_FOR", strconv.FormatBool(config.RateLimiter.TrustForwardedFor)})
+		rateLimits := [][2]string{}
+		for name, limits := range map[string]map[string]handlers.RateLimit{
+			"USER": config.RateLimiter.User, "IP": config.RateLimiter.IP} {
+			for cmd, limit := range limits {
+				rateLimits = append(rateLimits, [2]string{
+					"RATE_LIMIT_" + name + "_" + strings.ToUpper(cmd), formatRateLimit(limit)})
+			}
+		}
+		for group, limit := range config.RateLimiter.Group {
+			rateLimits = append(rateLimits, [2]string{
+				"RATE_LIMIT_GROUP_" + strings.ToUpper(string(group)), formatRateLimit(limit)})
+		}
+		sort.Slice(rateLimits, func(i, j int) bool { return rateLimits[i][0] < rateLimits[j][0] })
+		settings = append(settings, rateLimits...)
+	}
+	for _, setting := range settings {
+		fmt.Fprintf(w, "%v=%v\n", setting[0], setting[1])
+	}
+}
+
+func sortedCommands(timeouts handlers.UpstreamTimeouts) []string {
+	cmds := []string{}
+	for cmd := range timeouts {
+		cmds = append(cmds, cmd)
+	}
+	sort.Strings(cmds)
+	return cmds
+}
+
+func formatRateLimit(limit handlers.RateLimit) string {
+	return fmt.Sprintf("%v/%v", limit.Requests, limit.Per)
+}
+
+func maskSecret(secret string) string {
+	if len(secret) == 0 {
+		return ""
+	}
+	return "********"
+}
+
+func maskURL(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.User != nil {
+		u.User = url.User(handlers.Redacted)
+		return u.String()
+	}
+	return rawURL
+}
+
+// configLoader reads settings from the environment and records
+// invalid values.
+type configLoader struct {
+	problems []string
+}
+
+func (loader *configLoader) problem(format string, a ...interface{}) {
+	loader.problems = append(loader.problems, fmt.Sprintf(format, a...))
+}
+
+func (loader *configLoader) stringValue(name, defaultValue string) string {
+	if val := strings.TrimSpace(os.Getenv(name)); len(val) > 0 {
+		return val
+	}
+	return defaultValue
+}
+
+func (loader *configLoader) intValue(name string, defaultValue int) int {
+	raw := loader.stringValue(name, "")
+	if len(raw) == 0 {
+		return defaultValue
+	}
+	val, err := strconv.Atoi(raw)
+	if err != nil {
+		loader.problem("%v: invalid integer [%v]", name, raw)
+		return defaultValue
+	}
+	return val
+}
+
+func (loader *configLoader) boolValue(name string, defaultValue bool) bool {
+	raw := loader.stringValue(name, "")
+	if len(raw) == 0 {
+		return defaultValue
+	}
+	val, err := strconv.ParseBool(raw)
+	if err != nil {
+		loader.problem("%v: invalid boolean [%v]", name, raw)
+		return defaultValue
+	}
+	return val
+}
+
+// durationValue parses a duration. `0` is allowed to disable a feature.
+func (loader *configLoader) durationValue(name string, defaultValue time.Duration) time.Duration {
+	raw := loader.stringValue(name, "")
+	if len(raw) == 0 {
+		return defaultValue
+	}
+	val, err := time.ParseDuration(raw)
+	if err != nil || val < 0 {
+		loader.problem("%v: invalid duration [%v]", name, raw)
+		return defaultValue
+	}
+	return val
+}
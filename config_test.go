@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setConfigEnv sets environment variables and unsets them, and those
+// set from a config file, after the test.
+func setConfigEnv(t *testing.T, env map[string]string, fileSettings ...string) {
+	for name, value := range env {
+		os.Setenv(name, value)
+	}
+	t.Cleanup(func() {
+		for name := range env {
+			os.Unsetenv(name)
+		}
+		for _, name := range fileSettings {
+			os.Unsetenv(name)
+		}
+	})
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	err := ioutil.WriteFile(configFile, []byte(`{
+  "PORT": 8080,
+  "HTTP_ENGINE": "fasthttp",
+  "LOG_MASK_PHONE_NUMBERS": false,
+  "IDEMPOTENCY_WINDOW": "30s"
+}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setConfigEnv(t, map[string]string{
+		"RINGCENTRAL_SERVER_URL":    "https://platform.example.com",
+		"RINGCENTRAL_CLIENT_ID":     "id",
+		"RINGCENTRAL_CLIENT_SECRET": "client-secret",
+		"PORT":                      "9090",
+	}, "HTTP_ENGINE", "LOG_MASK_PHONE_NUMBERS", "IDEMPOTENCY_WINDOW")
+
+	config := LoadConfig(configFile)
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Config.Validate: %v", err)
+	}
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"PORT from environment", config.Port, 9090},
+		{"HTTP_ENGINE from file", config.HTTPEngine, "fasthttp"},
+		{"LOG_MASK_PHONE_NUMBERS from file", config.LogMaskPhoneNumbers, false},
+		{"IDEMPOTENCY_WINDOW from file", config.IdempotencyWindow, 30 * time.Second},
+		{"SHUTDOWN_GRACE_PERIOD default", config.ShutdownGracePeriod, DefaultShutdownGracePeriod},
+		{"METRICS_PORT default", config.MetricsPort, 0},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%v: want [%v], got [%v]", tt.name, tt.want, tt.got)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	config.Write(buf)
+	for _, want := range []string{"PORT=9090\n", "HTTP_ENGINE=fasthttp\n", "RINGCENTRAL_CLIENT_SECRET=********\n"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Config.Write: want [%v]", strings.TrimSpace(want))
+		}
+	}
+	if strings.Contains(buf.String(), "client-secret") {
+		t.Error("Config.Write: client secret not masked")
+	}
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	err := ioutil.WriteFile(configFile, []byte(`# Proxy settings
+PORT: 8080
+HTTP_ENGINE: fasthttp
+LOG_MASK_PHONE_NUMBERS: false
+IDEMPOTENCY_WINDOW: 30s
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setConfigEnv(t, map[string]string{
+		"RINGCENTRAL_SERVER_URL":    "https://platform.example.com",
+		"RINGCENTRAL_CLIENT_ID":     "id",
+		"RINGCENTRAL_CLIENT_SECRET": "client-secret",
+	}, "PORT", "HTTP_ENGINE", "LOG_MASK_PHONE_NUMBERS", "IDEMPOTENCY_WINDOW")
+
+	config := LoadConfig(configFile)
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Config.Validate: %v", err)
+	}
+	if config.Port != 8080 || config.HTTPEngine != "fasthttp" ||
+		config.LogMaskPhoneNumbers || config.IdempotencyWindow != 30*time.Second {
+		t.Errorf("LoadConfig(%q): got port [%v] engine [%v] mask [%v] window [%v]", "config.yaml",
+			config.Port, config.HTTPEngine, config.LogMaskPhoneNumbers, config.IdempotencyWindow)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		env      map[string]string
+		problems []string
+	}{
+		{map[string]string{"PORT": "abc", "HTTP_ENGINE": "gin"},
+			[]string{"PORT: invalid integer [abc]", "HTTP_ENGINE: invalid engine [gin]"}},
+		{map[string]string{"PORT": "70000", "LOG_MASK_PHONE_NUMBERS": "maybe"},
+			[]string{"PORT: invalid port [70000]", "LOG_MASK_PHONE_NUMBERS: invalid boolean [maybe]"}},
+		{map[string]string{"SHUTDOWN_GRACE_PERIOD": "-1s", "METRICS_PORT": "3000"},
+			[]string{"SHUTDOWN_GRACE_PERIOD: invalid duration [-1s]", "METRICS_PORT: invalid port [3000]"}},
+		{map[string]string{"ADMIN_PORT": "3002", "ADMIN_TOKEN": "short"},
+			[]string{"ADMIN_TOKEN: must be at least"}},
+		{map[string]string{"SMTP_ADDR": "smtp.example.com:587"},
+			[]string{"SMTP_FROM: required when SMTP_ADDR is set"}},
+		{map[string]string{"RINGCENTRAL_SERVER_URL": ""},
+			[]string{"RINGCENTRAL_SERVER_URL is not set"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.problems[0], func(t *testing.T) {
+			env := map[string]string{
+				"RINGCENTRAL_SERVER_URL":    "https://platform.example.com",
+				"RINGCENTRAL_CLIENT_ID":     "id",
+				"RINGCENTRAL_CLIENT_SECRET": "secret"}
+			for name, value := range tt.env {
+				env[name] = value
+			}
+			setConfigEnv(t, env)
+			err := LoadConfig("").Validate()
+			if err == nil {
+				t.Fatalf("Config.Validate(%v): want problems %v", tt.env, tt.problems)
+			}
+			for _, problem := range tt.problems {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("Config.Validate(%v): want [%v], got [%v]", tt.env, problem, err)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		filename string
+		content  string
+		problem  string
+	}{
+		{"config.json", `{"PORT": [3000]}`, "PORT: value must be a string, number or boolean"},
+		{"config.json", `PORT=3000`, "CONFIG_FILE: invalid character"},
+		{"config.json", "", "CONFIG_FILE: unexpected end of JSON input"},
+		{"config.yaml", "PORT: [3000]", "PORT: value must be a string, number or boolean"},
+		{"config.yml", "PORT: 3000: 3001", "CONFIG_FILE: yaml:"},
+	}
+	setConfigEnv(t, map[string]string{
+		"RINGCENTRAL_SERVER_URL":    "https://platform.example.com",
+		"RINGCENTRAL_CLIENT_ID":     "id",
+		"RINGCENTRAL_CLIENT_SECRET": "secret"})
+	for i, tt := range tests {
+		configFile := filepath.Join(dir, tt.filename)
+		if err := ioutil.WriteFile(configFile, []byte(tt.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		err := LoadConfig(configFile).Validate()
+		if err == nil || !strings.Contains(err.Error(), tt.problem) {
+			t.Errorf("config file %d: want [%v], got [%v]", i, tt.problem, err)
+		}
+	}
+	if err := LoadConfig(filepath.Join(dir, "missing.json")).Validate(); err == nil {
+		t.Error("missing config file: want error")
+	}
+}
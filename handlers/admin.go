@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	hum "github.com/grokify/gotilla/net/httputilmore"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+)
+
+const MinAdminTokenLength = 16
+
+// AdminAuth authorizes admin API requests with the
+// `Authorization: Bearer <token>` header.
+type AdminAuth struct {
+	Token string
+}
+
+// Authorized returns true if the request has the admin token. An empty
+// token authorizes no requests.
+func (auth AdminAuth) Authorized(aReq anyhttp.Request) bool {
+	if len(auth.Token) == 0 {
+		return false
+	}
+	header := strings.TrimSpace(GetHeader(aReq, "Authorization"))
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return false
+	}
+	// Hashes have the same length so the comparison takes the same
+	// time for any token.
+	want := sha256.Sum256([]byte(auth.Token))
+	got := sha256.Sum256([]byte(strings.TrimSpace(header[7:])))
+	return hmac.Equal(want[:], got[:])
+}
+
+// WriteUnauthorizedAdmin writes a `401` for an admin API request
+// without the admin token.
+func WriteUnauthorizedAdmin(aRes anyhttp.Response) {
+	SetHeader(aRes, "WWW-Authenticate", `Bearer realm="admin"`)
+	anyhttp.WriteSimpleJson(aRes, http.StatusUnauthorized, "Unauthorized")
+}
+
+// WriteJson writes `v` as a JSON response.
+func WriteJson(aRes anyhttp.Response, statusCode int, v interface{}) {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		WriteErrorJson(aRes, http.StatusInternalServerError, err)
+		return
+	}
+	aRes.SetContentType(hum.ContentTypeAppJsonUtf8)
+	aRes.SetStatusCode(statusCode)
+	aRes.SetBodyBytes(bytes)
+}
+
+// APIKeyInfo is an API key as returned by the admin API, without its
+// stored credentials.
+type APIKeyInfo struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	App       string    `json:"app,omitempty"`
+	GrantType string    `json:"grantType"`
+	Username  string    `json:"username,omitempty"`
+	Extension string    `json:"extension,omitempty"`
+	Created   time.Time `json:"created"`
+	// Key is only set when the key is created.
+	Key string `json:"key,omitempty"`
+}
+
+func NewAPIKeyInfo(apiKey APIKey) APIKeyInfo {
+	return APIKeyInfo{
+		ID:        apiKey.ID,
+		Name:      apiKey.Name,
+		App:       apiKey.App,
+		GrantType: apiKey.Credentials.GrantType,
+		Username:  MaskPhoneNumber(apiKey.Credentials.Username),
+		Extension: apiKey.Credentials.Extension,
+		Created:   apiKey.Created}
+}
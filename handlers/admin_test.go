@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+)
+
+func TestAdminAuthAuthorized(t *testing.T) {
+	const token = "0123456789abcdef-admin"
+	tests := []struct {
+		token  string
+		header string
+		want   bool
+	}{
+		{token, "Bearer " + token, true},
+		{token, "bearer  " + token + " ", true},
+		{token, "Bearer " + token + "x", false},
+		{token, "Bearer", false},
+		{token, "Basic " + token, false},
+		{token, token, false},
+		{token, "", false},
+		{"", "Bearer ", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/admin/tokens", nil)
+		if len(tt.header) > 0 {
+			req.Header.Set("Authorization", tt.header)
+		}
+		if got := (AdminAuth{Token: tt.token}).Authorized(anyhttp.NewRequestNetHttp(req)); got != tt.want {
+			t.Errorf("AdminAuth.Authorized(%q): want [%v], got [%v]", tt.header, tt.want, got)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	WriteUnauthorizedAdmin(anyhttp.NewResponseNetHttp(rec))
+	if rec.Code != http.StatusUnauthorized || !strings.HasPrefix(rec.Header().Get("WWW-Authenticate"), "Bearer") {
+		t.Errorf("WriteUnauthorizedAdmin: got [%v] [%v]", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestNewAPIKeyInfo(t *testing.T) {
+	apiKey := APIKey{
+		ID:          "abc",
+		Name:        "front desk",
+		App:         "sandbox",
+		SecretHash:  hashAPIKeySecret("secret"),
+		Credentials: NewStoredCredentials("+16505550100", "101", "p@ssw0rd!", "", "")}
+	rec := httptest.NewRecorder()
+	WriteJson(anyhttp.NewResponseNetHttp(rec), http.StatusOK, NewAPIKeyInfo(apiKey))
+	body := rec.Body.String()
+	info := APIKeyInfo{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.ID != "abc" || info.GrantType != GrantTypePassword || info.Username != "+*******0100" || info.Extension != "101" {
+		t.Errorf("NewAPIKeyInfo: got %+v", info)
+	}
+	for _, secret := range []string{"p@ssw0rd!", apiKey.SecretHash, "6505550100", `"key"`} {
+		if strings.Contains(body, secret) {
+			t.Errorf("APIKeyInfo JSON contains [%v]: %v", secret, body)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+	hum "github.com/grokify/gotilla/net/httputilmore"
+	"github.com/valyala/fasthttp"
+)
+
+// The `anyhttp` interfaces do not expose headers or cookies so the
+// functions below use the underlying `net/http` and `fasthttp` types.
+
+// responseWrapper is implemented by responses which wrap the
+// `net/http` or `fasthttp` response.
+type responseWrapper interface {
+	Unwrap() anyhttp.Response
+}
+
+// SetHeader sets a response header. For `net/http` this must be
+// called before the status code is set.
+func SetHeader(aRes anyhttp.Response, key, value string) {
+	switch res := aRes.(type) {
+	case anyhttp.ResponseNetHttp:
+		res.Raw.Header().Set(key, value)
+	case anyhttp.ResponseFastHttp:
+		res.Raw.Response.Header.Set(key, value)
+	case *ResponseRecorder:
+		res.Recorded.Header.Set(key, value)
+		SetHeader(res.Response, key, value)
+	case responseWrapper:
+		SetHeader(res.Unwrap(), key, value)
+	}
+}
+
+// AddHeader adds a response header value. For `net/http` this must
+// be called before the status code is set.
+func AddHeader(aRes anyhttp.Response, key, value string) {
+	switch res := aRes.(type) {
+	case anyhttp.ResponseNetHttp:
+		res.Raw.Header().Add(key, value)
+	case anyhttp.ResponseFastHttp:
+		res.Raw.Response.Header.Add(key, value)
+	case *ResponseRecorder:
+		res.Recorded.Header.Add(key, value)
+		AddHeader(res.Response, key, value)
+	case responseWrapper:
+		AddHeader(res.Unwrap(), key, value)
+	}
+}
+
+// GetHeader returns the named request header.
+func GetHeader(aReq anyhttp.Request, key string) string {
+	switch req := aReq.(type) {
+	case *anyhttp.RequestNetHttp:
+		return req.Raw.Header.Get(key)
+	case *anyhttp.RequestFastHttp:
+		return string(req.Raw.Request.Header.Peek(key))
+	}
+	return ""
+}
+
+// GetPath returns the request URL path.
+func GetPath(aReq anyhttp.Request) string {
+	switch req := aReq.(type) {
+	case *anyhttp.RequestNetHttp:
+		return req.Raw.URL.Path
+	case *anyhttp.RequestFastHttp:
+		return string(req.Raw.Path())
+	}
+	return ""
+}
+
+// GetHost returns the request `Host`.
+func GetHost(aReq anyhttp.Request) string {
+	switch req := aReq.(type) {
+	case *anyhttp.RequestNetHttp:
+		return req.Raw.Host
+	case *anyhttp.RequestFastHttp:
+		return string(req.Raw.Host())
+	}
+	return ""
+}
+
+// ClientIP returns the client IP address. If `trustForwardedFor` is true,
+// the last `X-Forwarded-For` address is used, which is the address seen
+// by a proxy such as the Heroku router.
+func ClientIP(aReq anyhttp.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		if forwarded := strings.Split(GetHeader(aReq, "X-Forwarded-For"), ","); len(forwarded) > 0 {
+			if ip := strings.TrimSpace(forwarded[len(forwarded)-1]); len(ip) > 0 {
+				return ip
+			}
+		}
+	}
+	switch req := aReq.(type) {
+	case *anyhttp.RequestNetHttp:
+		if host, _, err := net.SplitHostPort(req.Raw.RemoteAddr); err == nil {
+			return host
+		}
+		return req.Raw.RemoteAddr
+	case *anyhttp.RequestFastHttp:
+		return req.Raw.RemoteIP().String()
+	}
+	return ""
+}
+
+// GetCookie returns the value of the named request cookie or an
+// empty string if it is not present.
+func GetCookie(aReq anyhttp.Request, name string) string {
+	switch req := aReq.(type) {
+	case *anyhttp.RequestNetHttp:
+		if cookie, err := req.Raw.Cookie(name); err == nil {
+			return cookie.Value
+		}
+	case *anyhttp.RequestFastHttp:
+		return string(req.Raw.Request.Header.Cookie(name))
+	}
+	return ""
+}
+
+// SetCookie adds a `Set-Cookie` header to the response. For `net/http`
+// this must be called before the status code is set.
+func SetCookie(aRes anyhttp.Response, cookie *http.Cookie) {
+	switch res := aRes.(type) {
+	case anyhttp.ResponseNetHttp:
+		http.SetCookie(res.Raw, cookie)
+	case anyhttp.ResponseFastHttp:
+		fastCookie := fasthttp.AcquireCookie()
+		defer fasthttp.ReleaseCookie(fastCookie)
+		fastCookie.SetKey(cookie.Name)
+		fastCookie.SetValue(cookie.Value)
+		fastCookie.SetPath(cookie.Path)
+		fastCookie.SetHTTPOnly(cookie.HttpOnly)
+		fastCookie.SetSecure(cookie.Secure)
+		if !cookie.Expires.IsZero() {
+			fastCookie.SetExpire(cookie.Expires)
+		}
+		res.Raw.Response.Header.SetCookie(fastCookie)
+	case *ResponseRecorder:
+		res.Recorded.Header.Add("Set-Cookie", cookie.String())
+		SetCookie(res.Response, cookie)
+	case responseWrapper:
+		SetCookie(res.Unwrap(), cookie)
+	}
+}
+
+// WriteLegacyErrorAnyResponse writes an error for legacy clients which treat
+// any response not starting with `OK` or a numeric code as an error so the
+// legacy format is a single `ERROR <reason>` line. The `json` format
+// includes the error text.
+func WriteLegacyErrorAnyResponse(aRes anyhttp.Response, statusCode int, responseFormat, reason string, err error) {
+	if responseFormat == "json" {
+		WriteErrorJson(aRes, statusCode, err)
+		return
+	}
+	aRes.SetContentType(hum.ContentTypeTextPlainUsAscii)
+	aRes.SetStatusCode(statusCode)
+	aRes.SetBodyBytes([]byte(fmt.Sprintf("ERROR %s", reason)))
+}
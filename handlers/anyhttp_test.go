@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+	"github.com/valyala/fasthttp"
+)
+
+func TestCookieAndHeaders(t *testing.T) {
+	cookie := &http.Cookie{Name: SessionCookieName, Value: "abc", Path: "/",
+		Expires: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), HttpOnly: true}
+
+	// net/http
+	rec := httptest.NewRecorder()
+	aRes := anyhttp.NewResponseNetHttp(rec)
+	SetHeader(aRes, "X-Request-Id", "id1")
+	AddHeader(aRes, "Vary", "A")
+	AddHeader(aRes, "Vary", "B")
+	SetCookie(aRes, cookie)
+	aRes.SetStatusCode(http.StatusOK)
+	req := httptest.NewRequest(http.MethodGet, "http://proxy.example.com/ringout.asp", nil)
+	req.Header.Set("Cookie", rec.Header().Get("Set-Cookie"))
+	req.Header.Set("X-Api-Key", "key")
+	netReq := anyhttp.NewRequestNetHttp(req)
+
+	// fasthttp
+	ctx := &fasthttp.RequestCtx{}
+	fastRes := anyhttp.NewResponseFastHttp(ctx)
+	SetHeader(fastRes, "X-Request-Id", "id1")
+	AddHeader(fastRes, "Vary", "A")
+	AddHeader(fastRes, "Vary", "B")
+	SetCookie(fastRes, cookie)
+	ctx.Request.SetRequestURI("http://proxy.example.com/ringout.asp")
+	ctx.Request.Header.Set("Cookie", SessionCookieName+"=abc")
+	ctx.Request.Header.Set("X-Api-Key", "key")
+	fastReq := anyhttp.NewRequestFastHttp(ctx)
+
+	if got := rec.Header().Get("X-Request-Id"); got != "id1" {
+		t.Errorf("net/http SetHeader: got [%v]", got)
+	}
+	if got := rec.Header()["Vary"]; len(got) != 2 {
+		t.Errorf("net/http AddHeader: got %v", got)
+	}
+	if got := string(ctx.Response.Header.Peek("X-Request-Id")); got != "id1" {
+		t.Errorf("fasthttp SetHeader: got [%v]", got)
+	}
+	setCookie := ctx.Response.Header.String()
+	for _, want := range []string{SessionCookieName + "=abc", "path=/", "HttpOnly", "2030"} {
+		if !strings.Contains(setCookie, want) {
+			t.Errorf("fasthttp SetCookie: want [%v] in [%v]", want, setCookie)
+		}
+	}
+
+	tests := []struct {
+		engine string
+		aReq   anyhttp.Request
+	}{
+		{"nethttp", netReq},
+		{"fasthttp", fastReq},
+	}
+	for _, tt := range tests {
+		if got := GetCookie(tt.aReq, SessionCookieName); got != "abc" {
+			t.Errorf("%v GetCookie: want [abc], got [%v]", tt.engine, got)
+		}
+		if got := GetCookie(tt.aReq, "missing"); got != "" {
+			t.Errorf("%v GetCookie(missing): got [%v]", tt.engine, got)
+		}
+		if got := GetHeader(tt.aReq, "x-api-key"); got != "key" {
+			t.Errorf("%v GetHeader: want [key], got [%v]", tt.engine, got)
+		}
+		if got := GetPath(tt.aReq); got != "/ringout.asp" {
+			t.Errorf("%v GetPath: got [%v]", tt.engine, got)
+		}
+		if got := GetHost(tt.aReq); got != "proxy.example.com" {
+			t.Errorf("%v GetHost: got [%v]", tt.engine, got)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		trust      bool
+		want       string
+	}{
+		{"10.0.0.1:1234", "", false, "10.0.0.1"},
+		{"10.0.0.1:1234", "203.0.113.1", false, "10.0.0.1"},
+		{"10.0.0.1:1234", "203.0.113.1", true, "203.0.113.1"},
+		{"10.0.0.1:1234", "198.51.100.1, 203.0.113.1", true, "203.0.113.1"},
+		{"10.0.0.1:1234", "", true, "10.0.0.1"},
+		{"10.0.0.1", "", false, "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if len(tt.forwarded) > 0 {
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if got := ClientIP(anyhttp.NewRequestNetHttp(req), tt.trust); got != tt.want {
+			t.Errorf("ClientIP(%v, %q, %v): want [%v], got [%v]", tt.remoteAddr, tt.forwarded, tt.trust, tt.want, got)
+		}
+	}
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Init(&fasthttp.Request{}, &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 1234}, nil)
+	if got := ClientIP(anyhttp.NewRequestFastHttp(ctx), false); got != "10.0.0.2" {
+		t.Errorf("fasthttp ClientIP: want [10.0.0.2], got [%v]", got)
+	}
+}
+
+func TestWriteLegacyErrorAnyResponse(t *testing.T) {
+	tests := []struct {
+		format string
+		body   string
+	}{
+		{"", "ERROR InvalidSessionID"},
+		{"text", "ERROR InvalidSessionID"},
+		{"json", "Invalid SessionID [x] password=[REDACTED]"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		WriteLegacyErrorAnyResponse(anyhttp.NewResponseNetHttp(rec), http.StatusBadRequest, tt.format,
+			"InvalidSessionID", errors.New("Invalid SessionID [x] password=s3cret"))
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tt.body) {
+			t.Errorf("format [%v]: want [400 %v], got [%v %v]", tt.format, tt.body, rec.Code, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,509 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	rc "github.com/grokify/go-ringcentral/client"
+	ro "github.com/grokify/oauth2more/ringcentral"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/oauth2"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+)
+
+const (
+	APIKeyPrefix           = "rclk_"
+	DefaultAPIKeyHeader    = "X-Api-Key"
+	MinAPIKeyStoreSecret   = 16
+	GrantTypePassword      = "password"
+	GrantTypeJWT           = "jwt"
+	GrantTypeRefreshToken  = "refresh_token"
+	grantTypeJWTBearerURN  = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	apiKeyStoreFileVersion = 1
+)
+
+// Store files start with a header and a salt. The file key is derived
+// from the secret and salt with Argon2id using these parameters.
+const (
+	apiKeyStoreHeader     = "RCLKS1"
+	apiKeyStoreSaltSize   = 16
+	apiKeyStoreKDFTime    = 2
+	apiKeyStoreKDFMemory  = 19 * 1024
+	apiKeyStoreKDFThreads = 1
+)
+
+// StoredCredentials are the RingCentral user credentials an API key
+// resolves to: a password grant, a JWT or a refresh token.
+type StoredCredentials struct {
+	GrantType    string `json:"grantType"`
+	Username     string `json:"username,omitempty"`
+	Extension    string `json:"extension,omitempty"`
+	Password     string `json:"password,omitempty"`
+	JWT          string `json:"jwt,omitempty"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+}
+
+// NewStoredCredentials returns credentials for the JWT grant if `jwt`
+// is set, the refresh token grant if `refreshToken` is set, or else the
+// password grant.
+func NewStoredCredentials(username, extension, password, jwt, refreshToken string) StoredCredentials {
+	creds := StoredCredentials{
+		Username:  strings.TrimSpace(username),
+		Extension: strings.TrimSpace(extension)}
+	switch {
+	case len(jwt) > 0:
+		creds.GrantType = GrantTypeJWT
+		creds.JWT = jwt
+	case len(refreshToken) > 0:
+		creds.GrantType = GrantTypeRefreshToken
+		creds.RefreshToken = refreshToken
+	default:
+		creds.GrantType = GrantTypePassword
+		creds.Password = password
+	}
+	return creds
+}
+
+// Validate checks the credentials for the grant type are set.
+func (creds StoredCredentials) Validate() error {
+	switch creds.GrantType {
+	case GrantTypePassword:
+		if len(creds.Username) == 0 || len(creds.Password) == 0 {
+			return fmt.Errorf("username and password are required for the password grant")
+		}
+	case GrantTypeJWT:
+		if len(creds.JWT) == 0 {
+			return fmt.Errorf("a JWT is required for the jwt grant")
+		}
+	case GrantTypeRefreshToken:
+		if len(creds.RefreshToken) == 0 {
+			return fmt.Errorf("a refresh token is required for the refresh_token grant")
+		}
+	default:
+		return fmt.Errorf("invalid grant type [%v]", creds.GrantType)
+	}
+	return nil
+}
+
+// URLValues returns the token request parameters.
+func (creds StoredCredentials) URLValues() url.Values {
+	switch creds.GrantType {
+	case GrantTypeJWT:
+		return url.Values{
+			"grant_type": {grantTypeJWTBearerURN},
+			"assertion":  {creds.JWT}}
+	case GrantTypeRefreshToken:
+		return url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {creds.RefreshToken}}
+	}
+	pwdCreds := ro.PasswordCredentials{
+		Username:  creds.Username,
+		Extension: creds.Extension,
+		Password:  creds.Password}
+	return pwdCreds.URLValues()
+}
+
+// APIKey is a proxy-issued key which legacy clients send instead of a
+// RingCentral password. Only a hash of the key secret is stored.
+type APIKey struct {
+	ID          string            `json:"id"`
+	Name        string            `json:"name"`
+	App         string            `json:"app,omitempty"`
+	SecretHash  string            `json:"secretHash"`
+	Credentials StoredCredentials `json:"credentials"`
+	Created     time.Time         `json:"created"`
+}
+
+// APIKeyStore is a concurrency-safe store of API keys persisted to a
+// file encrypted with AES-256-GCM using a key derived from a secret with
+// Argon2id.
+// The file is shared with other processes, such as the `apikey` command:
+// writes lock the file and apply the change to its latest contents, and
+// reads reload it when it has changed.
+type APIKeyStore struct {
+	Path string
+	// Header is the request header clients can send the API key in
+	// instead of `password`.
+	Header string
+	// Required rejects requests with RingCentral passwords.
+	Required bool
+	mutex    sync.Mutex
+	secret   string
+	salt     []byte
+	aead     cipher.AEAD
+	keys     map[string]*APIKey
+	modTime  time.Time
+	size     int64
+}
+
+type apiKeyStoreFile struct {
+	Version int       `json:"version"`
+	Keys    []*APIKey `json:"keys"`
+}
+
+// NewAPIKeyStore opens the store at `path`, which is created on the
+// first write if it does not exist.
+func NewAPIKeyStore(path, secret string) (*APIKeyStore, error) {
+	if len(secret) < MinAPIKeyStoreSecret {
+		return nil, fmt.Errorf("API key store secret must be at least %v characters", MinAPIKeyStoreSecret)
+	}
+	store := &APIKeyStore{
+		Path:   path,
+		Header: DefaultAPIKeyHeader,
+		secret: secret,
+		keys:   map[string]*APIKey{}}
+	return store, store.load()
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// useSalt sets the cipher for the file key derived from the secret and
+// `salt`. Derivation is slow so the cipher is kept while the salt is
+// unchanged.
+func (store *APIKeyStore) useSalt(salt []byte) error {
+	if store.aead != nil && store.salt != nil && bytes.Equal(salt, store.salt) {
+		return nil
+	}
+	aead, err := newAESGCM(argon2.IDKey([]byte(store.secret), salt,
+		apiKeyStoreKDFTime, apiKeyStoreKDFMemory, apiKeyStoreKDFThreads, 32))
+	if err != nil {
+		return err
+	}
+	store.salt = append([]byte{}, salt...)
+	store.aead = aead
+	return nil
+}
+
+// NewAPIKeyStoreEnv opens the store at `API_KEY_STORE` encrypted with
+// `API_KEY_STORE_SECRET`. `API_KEY_HEADER` sets the request header and
+// `API_KEYS_REQUIRED` rejects RingCentral passwords. It returns nil if
+// `API_KEY_STORE` is not set.
+func NewAPIKeyStoreEnv() (*APIKeyStore, error) {
+	path := strings.TrimSpace(os.Getenv("API_KEY_STORE"))
+	required := strings.EqualFold(strings.TrimSpace(os.Getenv("API_KEYS_REQUIRED")), "true")
+	if len(path) == 0 {
+		if required {
+			return nil, fmt.Errorf("API_KEYS_REQUIRED: API_KEY_STORE is not set")
+		}
+		return nil, nil
+	}
+	store, err := NewAPIKeyStore(path, os.Getenv("API_KEY_STORE_SECRET"))
+	if err != nil {
+		return nil, fmt.Errorf("API_KEY_STORE: %v", err)
+	}
+	if header := strings.TrimSpace(os.Getenv("API_KEY_HEADER")); len(header) > 0 {
+		store.Header = header
+	}
+	store.Required = required
+	return store, nil
+}
+
+// Create adds an API key for the credentials and returns the key, which
+// cannot be retrieved later. `app` is the app name to use or empty to
+// select the app per request.
+func (store *APIKeyStore) Create(name, app string, creds StoredCredentials) (string, APIKey, error) {
+	if err := creds.Validate(); err != nil {
+		return "", APIKey{}, err
+	}
+	idBytes := make([]byte, 6)
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", APIKey{}, err
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", APIKey{}, err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(secretBytes)
+	apiKey := &APIKey{
+		ID:          hex.EncodeToString(idBytes),
+		Name:        strings.TrimSpace(name),
+		App:         strings.ToLower(strings.TrimSpace(app)),
+		SecretHash:  hashAPIKeySecret(secret),
+		Credentials: creds,
+		Created:     time.Now().UTC()}
+
+	err := store.update(func() error {
+		store.keys[apiKey.ID] = apiKey
+		return nil
+	})
+	if err != nil {
+		return "", APIKey{}, err
+	}
+	return APIKeyPrefix + apiKey.ID + "_" + secret, *apiKey, nil
+}
+
+// Revoke deletes the API key with the ID.
+func (store *APIKeyStore) Revoke(id string) error {
+	return store.update(func() error {
+		if _, ok := store.keys[id]; !ok {
+			return fmt.Errorf("API key [%v] not found", id)
+		}
+		delete(store.keys, id)
+		return nil
+	})
+}
+
+// List returns the API keys sorted by creation time.
+func (store *APIKeyStore) List() []APIKey {
+	apiKeys := []APIKey{}
+	if store == nil {
+		return apiKeys
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.reloadIfChanged()
+	for _, apiKey := range store.keys {
+		apiKeys = append(apiKeys, *apiKey)
+	}
+	sort.Slice(apiKeys, func(i, j int) bool { return apiKeys[i].Created.Before(apiKeys[j].Created) })
+	return apiKeys
+}
+
+// Lookup returns the API key for a key sent by a client.
+func (store *APIKeyStore) Lookup(key string) (APIKey, bool) {
+	id := APIKeyID(key)
+	if store == nil || len(id) == 0 {
+		return APIKey{}, false
+	}
+	secret := strings.TrimPrefix(key, APIKeyPrefix+id+"_")
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.reloadIfChanged()
+	apiKey, ok := store.keys[id]
+	if !ok || !hmac.Equal([]byte(apiKey.SecretHash), []byte(hashAPIKeySecret(secret))) {
+		return APIKey{}, false
+	}
+	return *apiKey, true
+}
+
+// Has returns true if the store has an API key with the ID.
+func (store *APIKeyStore) Has(id string) bool {
+	if store == nil || len(id) == 0 {
+		return false
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.reloadIfChanged()
+	_, ok := store.keys[id]
+	return ok
+}
+
+// RequestAPIKey returns the API key in the `Header` request header or
+// the `password` parameter, or an empty string if there is none.
+func (store *APIKeyStore) RequestAPIKey(aReq anyhttp.Request, password string) string {
+	if store == nil {
+		return ""
+	}
+	if key := strings.TrimSpace(GetHeader(aReq, store.Header)); len(key) > 0 {
+		return key
+	}
+	if password = strings.TrimSpace(password); strings.HasPrefix(password, APIKeyPrefix) {
+		return password
+	}
+	return ""
+}
+
+// IsRequired returns true if requests must use an API key.
+func (store *APIKeyStore) IsRequired() bool {
+	return store != nil && store.Required
+}
+
+// APIClient returns a cached API client for the API key's stored
+// credentials. Refresh tokens are rotated by the REST API so the latest
+// refresh token is saved to the store.
+func (store *APIKeyStore) APIClient(ctx context.Context, cache *TokenCache, app ro.ApplicationCredentials, apiKey APIKey) (*rc.APIClient, error) {
+	var onToken func(*oauth2.Token)
+	if apiKey.Credentials.GrantType == GrantTypeRefreshToken {
+		onToken = func(token *oauth2.Token) {
+			if err := store.updateRefreshToken(apiKey.ID, token.RefreshToken); err != nil {
+				log.Warnf("Refresh token not saved for API key [%v]: %v", apiKey.ID, err)
+			}
+		}
+	}
+	return cache.GrantAPIClient(ctx, app, "apikey:"+apiKey.ID, apiKey.Credentials.URLValues(), onToken)
+}
+
+func (store *APIKeyStore) updateRefreshToken(id, refreshToken string) error {
+	if len(refreshToken) == 0 {
+		return nil
+	}
+	return store.update(func() error {
+		if apiKey, ok := store.keys[id]; ok {
+			apiKey.Credentials.RefreshToken = refreshToken
+		}
+		return nil
+	})
+}
+
+// APIKeyID returns the ID part of a key in the format
+// `rclk_<id>_<secret>`, or an empty string if the key is malformed.
+func APIKeyID(key string) string {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return ""
+	}
+	parts := strings.SplitN(strings.TrimPrefix(key, APIKeyPrefix), "_", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return ""
+	}
+	return parts[0]
+}
+
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// update applies `fn` to the latest keys in the store file and saves
+// them, holding a lock on the file so changes by other processes are
+// not lost.
+func (store *APIKeyStore) update(fn func() error) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	unlock, err := lockFile(store.Path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := store.load(); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	if err := store.save(); err != nil {
+		// Discard the unsaved change.
+		store.load()
+		return err
+	}
+	return nil
+}
+
+// reloadIfChanged loads the store file if its modification time or size
+// has changed since it was last loaded or saved. It must be called with
+// the mutex held.
+func (store *APIKeyStore) reloadIfChanged() {
+	info, err := os.Stat(store.Path)
+	if os.IsNotExist(err) && store.modTime.IsZero() {
+		return
+	} else if err == nil && info.ModTime().Equal(store.modTime) && info.Size() == store.size {
+		return
+	}
+	if err := store.load(); err != nil {
+		log.Warnf("API key store [%v] not reloaded: %v", store.Path, err)
+	}
+}
+
+// load replaces the keys with the store file contents. It must be
+// called with the mutex held.
+func (store *APIKeyStore) load() error {
+	file, err := os.Open(store.Path)
+	if os.IsNotExist(err) {
+		store.keys = map[string]*APIKey{}
+		store.modTime, store.size = time.Time{}, 0
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(data, []byte(apiKeyStoreHeader)) ||
+		len(data) < len(apiKeyStoreHeader)+apiKeyStoreSaltSize {
+		return fmt.Errorf("API key store [%v] is corrupt", store.Path)
+	}
+	data = data[len(apiKeyStoreHeader):]
+	if err := store.useSalt(data[:apiKeyStoreSaltSize]); err != nil {
+		return err
+	}
+	data = data[apiKeyStoreSaltSize:]
+	nonceSize := store.aead.NonceSize()
+	if len(data) < nonceSize {
+		return fmt.Errorf("API key store [%v] is corrupt", store.Path)
+	}
+	plaintext, err := store.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return fmt.Errorf("API key store [%v] cannot be decrypted, check the secret", store.Path)
+	}
+	storeFile := apiKeyStoreFile{}
+	if err := json.Unmarshal(plaintext, &storeFile); err != nil {
+		return err
+	}
+	store.keys = map[string]*APIKey{}
+	for _, apiKey := range storeFile.Keys {
+		store.keys[apiKey.ID] = apiKey
+	}
+	store.modTime, store.size = info.ModTime(), info.Size()
+	return nil
+}
+
+// save writes the encrypted store to a temporary file which replaces
+// the store file. It must be called by `update`.
+func (store *APIKeyStore) save() error {
+	file := apiKeyStoreFile{Version: apiKeyStoreFileVersion, Keys: []*APIKey{}}
+	for _, apiKey := range store.keys {
+		file.Keys = append(file.Keys, apiKey)
+	}
+	sort.Slice(file.Keys, func(i, j int) bool { return file.Keys[i].ID < file.Keys[j].ID })
+	plaintext, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	if store.salt == nil {
+		salt := make([]byte, apiKeyStoreSaltSize)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return err
+		}
+		if err := store.useSalt(salt); err != nil {
+			return err
+		}
+	}
+	nonce := make([]byte, store.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	data := append([]byte(apiKeyStoreHeader), store.salt...)
+	data = append(data, store.aead.Seal(nonce, nonce, plaintext, nil)...)
+	tmpPath := store.Path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, store.Path); err != nil {
+		return err
+	}
+	if info, err := os.Stat(store.Path); err == nil {
+		store.modTime, store.size = info.ModTime(), info.Size()
+	}
+	return nil
+}
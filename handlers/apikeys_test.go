@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testAPIKeyStoreSecret = "0123456789abcdef-secret"
+
+func newTestAPIKeyStore(t *testing.T, path string) *APIKeyStore {
+	store, err := NewAPIKeyStore(path, testAPIKeyStoreSecret)
+	if err != nil {
+		t.Fatalf("NewAPIKeyStore: %v", err)
+	}
+	return store
+}
+
+func TestAPIKeyStoreEncryptLoadRevoke(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apikeys.enc")
+	store := newTestAPIKeyStore(t, path)
+
+	creds := NewStoredCredentials("+16505550100", "101", "p@ssw0rd!", "", "")
+	key, apiKey, err := store.Create("front desk", "Sandbox", creds)
+	if err != nil {
+		t.Fatalf("APIKeyStore.Create: %v", err)
+	}
+	if APIKeyID(key) != apiKey.ID || apiKey.App != "sandbox" {
+		t.Errorf("APIKeyStore.Create: got key [%v] ID [%v] app [%v]", key, apiKey.ID, apiKey.App)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, plain := range []string{creds.Password, creds.Username, apiKey.Name, strings.TrimPrefix(key, APIKeyPrefix+apiKey.ID+"_")} {
+		if bytes.Contains(data, []byte(plain)) {
+			t.Errorf("store file contains plaintext [%v]", plain)
+		}
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("store file mode: want [0600], got [%v] %v", info.Mode().Perm(), err)
+	}
+
+	if !bytes.HasPrefix(data, []byte(apiKeyStoreHeader)) {
+		t.Error("store file has no key derivation header")
+	}
+
+	if _, err := NewAPIKeyStore(path, "another-secret-0123456789"); err == nil {
+		t.Error("NewAPIKeyStore with wrong secret: want error")
+	}
+	if _, err := NewAPIKeyStore(path, "short"); err == nil {
+		t.Error("NewAPIKeyStore with short secret: want error")
+	}
+
+	loaded := newTestAPIKeyStore(t, path)
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{key, true},
+		{key + "x", false},
+		{APIKeyPrefix + apiKey.ID + "_wrong", false},
+		{"p@ssw0rd!", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		got, ok := loaded.Lookup(tt.key)
+		if ok != tt.want {
+			t.Errorf("APIKeyStore.Lookup(%q): want [%v], got [%v]", tt.key, tt.want, ok)
+		} else if ok && got.Credentials != creds {
+			t.Errorf("APIKeyStore.Lookup(%q): want credentials [%v], got [%v]", tt.key, creds, got.Credentials)
+		}
+	}
+
+	if err := loaded.Revoke(apiKey.ID); err != nil {
+		t.Fatalf("APIKeyStore.Revoke: %v", err)
+	}
+	if err := loaded.Revoke(apiKey.ID); err == nil {
+		t.Error("APIKeyStore.Revoke twice: want error")
+	}
+	if _, ok := newTestAPIKeyStore(t, path).Lookup(key); ok {
+		t.Error("revoked key found after reload")
+	}
+}
+
+// TestAPIKeyStoreSharedFile uses two stores for one file, as the server
+// and the `apikey` command do.
+func TestAPIKeyStoreSharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apikeys.enc")
+	server := newTestAPIKeyStore(t, path)
+	cli := newTestAPIKeyStore(t, path)
+
+	serverKey, _, err := server.Create("server", "", NewStoredCredentials("+16505550100", "", "password", "", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cliKey, cliAPIKey, err := cli.Create("cli", "", NewStoredCredentials("+16505550101", "", "password", "", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Lookup(cliKey); !ok {
+		t.Error("key created by another store not found")
+	}
+	if _, ok := cli.Lookup(serverKey); !ok {
+		t.Error("key created before another store's write was lost")
+	}
+
+	if err := cli.Revoke(cliAPIKey.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Lookup(cliKey); ok {
+		t.Error("key revoked by another store still found")
+	}
+	if got := len(server.List()); got != 1 {
+		t.Errorf("APIKeyStore.List: want [1] key, got [%v]", got)
+	}
+
+	// Concurrent writers do not lose each other's keys.
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		store := server
+		if i%2 == 1 {
+			store = cli
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := store.Create("concurrent", "", NewStoredCredentials("+16505550102", "", "password", "", "")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := len(newTestAPIKeyStore(t, path).List()); got != 11 {
+		t.Errorf("keys after concurrent writes: want [11], got [%v]", got)
+	}
+}
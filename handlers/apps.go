@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+	ro "github.com/grokify/oauth2more/ringcentral"
+)
+
+const (
+	DefaultAppName   = "default"
+	DefaultAppHeader = "X-RingCentral-App"
+)
+
+// App is a named set of RingCentral app credentials and the rules
+// which select it for a request.
+type App struct {
+	Name            string
+	Credentials     ro.ApplicationCredentials
+	Hosts           []string
+	PathPrefix      string
+	UsernamePattern *regexp.Regexp
+	// EnvPrefix is the environment variable prefix for the app
+	// settings, e.g. `RINGCENTRAL_APP_SANDBOX_`.
+	EnvPrefix string
+}
+
+// Validate checks the app credentials.
+func (app *App) Validate() error {
+	return validateAppCredentials(app.Credentials, app.EnvPrefix)
+}
+
+// AppRegistry selects the app for a request by, in order, path prefix,
+// the `Header` request header set to the app name, request hostname,
+// and username pattern, falling back to the `Default` app.
+type AppRegistry struct {
+	Default string
+	Header  string
+	apps    map[string]*App
+}
+
+func NewAppRegistry() *AppRegistry {
+	return &AppRegistry{
+		Default: DefaultAppName,
+		Header:  DefaultAppHeader,
+		apps:    map[string]*App{}}
+}
+
+// NewAppRegistryEnv returns the apps configured with environment
+// variables. The `RINGCENTRAL_SERVER_URL`, `RINGCENTRAL_CLIENT_ID` and
+// `RINGCENTRAL_CLIENT_SECRET` app is named `default`. Other apps are
+// listed in `RINGCENTRAL_APPS`, e.g. `sandbox,production`, and each is
+// configured with `RINGCENTRAL_APP_<NAME>_` variables: `SERVER_URL`,
+// `CLIENT_ID`, `CLIENT_SECRET`, `HOSTS`, `PATH_PREFIX` and
+// `USERNAME_PATTERN`. `RINGCENTRAL_APP_DEFAULT` names the default app
+// and `RINGCENTRAL_APP_HEADER` names the selection header.
+func NewAppRegistryEnv() (*AppRegistry, error) {
+	reg := NewAppRegistry()
+	defaultApp := &App{
+		Name:      DefaultAppName,
+		EnvPrefix: "RINGCENTRAL_",
+		Credentials: ro.ApplicationCredentials{
+			ServerURL:    strings.TrimSpace(os.Getenv("RINGCENTRAL_SERVER_URL")),
+			ClientID:     strings.TrimSpace(os.Getenv("RINGCENTRAL_CLIENT_ID")),
+			ClientSecret: strings.TrimSpace(os.Getenv("RINGCENTRAL_CLIENT_SECRET"))}}
+
+	names := splitList(os.Getenv("RINGCENTRAL_APPS"))
+	if len(names) == 0 || len(defaultApp.Credentials.ServerURL+defaultApp.Credentials.ClientID) > 0 {
+		if err := reg.Add(defaultApp); err != nil {
+			return reg, err
+		}
+	}
+	for _, name := range names {
+		prefix := "RINGCENTRAL_APP_" + strings.ToUpper(name) + "_"
+		app := &App{
+			Name:      strings.ToLower(name),
+			EnvPrefix: prefix,
+			Credentials: ro.ApplicationCredentials{
+				ServerURL:    strings.TrimSpace(os.Getenv(prefix + "SERVER_URL")),
+				ClientID:     strings.TrimSpace(os.Getenv(prefix + "CLIENT_ID")),
+				ClientSecret: strings.TrimSpace(os.Getenv(prefix + "CLIENT_SECRET"))},
+			Hosts:      splitList(os.Getenv(prefix + "HOSTS")),
+			PathPrefix: strings.TrimSpace(os.Getenv(prefix + "PATH_PREFIX"))}
+		if pattern := strings.TrimSpace(os.Getenv(prefix + "USERNAME_PATTERN")); len(pattern) > 0 {
+			rx, err := regexp.Compile(pattern)
+			if err != nil {
+				return reg, fmt.Errorf("%vUSERNAME_PATTERN: %v", prefix, err)
+			}
+			app.UsernamePattern = rx
+		}
+		if err := reg.Add(app); err != nil {
+			return reg, err
+		}
+	}
+	if name := strings.TrimSpace(os.Getenv("RINGCENTRAL_APP_DEFAULT")); len(name) > 0 {
+		reg.Default = strings.ToLower(name)
+	}
+	if header := strings.TrimSpace(os.Getenv("RINGCENTRAL_APP_HEADER")); len(header) > 0 {
+		reg.Header = header
+	}
+	return reg, nil
+}
+
+// Add adds an app. Path prefixes are normalized to start with `/`
+// and have no trailing `/`.
+func (reg *AppRegistry) Add(app *App) error {
+	app.Name = strings.ToLower(strings.TrimSpace(app.Name))
+	if len(app.Name) == 0 {
+		return fmt.Errorf("app name is empty")
+	}
+	if _, ok := reg.apps[app.Name]; ok {
+		return fmt.Errorf("app [%v] is already configured", app.Name)
+	}
+	if prefix := strings.Trim(strings.TrimSpace(app.PathPrefix), "/"); len(prefix) > 0 {
+		app.PathPrefix = "/" + prefix
+	} else {
+		app.PathPrefix = ""
+	}
+	for i, host := range app.Hosts {
+		app.Hosts[i] = strings.ToLower(host)
+	}
+	reg.apps[app.Name] = app
+	return nil
+}
+
+// Get returns the named app.
+func (reg *AppRegistry) Get(name string) (*App, bool) {
+	if reg == nil {
+		return nil, false
+	}
+	app, ok := reg.apps[strings.ToLower(strings.TrimSpace(name))]
+	return app, ok
+}
+
+// Apps returns the apps sorted by name.
+func (reg *AppRegistry) Apps() []*App {
+	apps := []*App{}
+	if reg == nil {
+		return apps
+	}
+	for _, app := range reg.apps {
+		apps = append(apps, app)
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i].Name < apps[j].Name })
+	return apps
+}
+
+// Names returns the names of the apps other than the `default` app,
+// as in `RINGCENTRAL_APPS`.
+func (reg *AppRegistry) Names() []string {
+	names := []string{}
+	for _, app := range reg.Apps() {
+		if app.Name != DefaultAppName {
+			names = append(names, app.Name)
+		}
+	}
+	return names
+}
+
+// PathPrefixes returns the app path prefixes so routes can also
+// match under them, e.g. `/sandbox/ringout.asp`.
+func (reg *AppRegistry) PathPrefixes() []string {
+	prefixes := []string{}
+	for _, app := range reg.Apps() {
+		if len(app.PathPrefix) > 0 {
+			prefixes = append(prefixes, app.PathPrefix)
+		}
+	}
+	return prefixes
+}
+
+// Validate checks there is a default app and all apps are valid.
+func (reg *AppRegistry) Validate() error {
+	problems := []string{}
+	if _, ok := reg.Get(reg.Default); !ok {
+		if reg.Default == DefaultAppName {
+			problems = append(problems, "RINGCENTRAL_SERVER_URL is not set")
+		} else {
+			problems = append(problems, fmt.Sprintf("RINGCENTRAL_APP_DEFAULT: app [%v] is not configured", reg.Default))
+		}
+	}
+	for _, app := range reg.Apps() {
+		if err := app.Validate(); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%v", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Select returns the app for a request and username.
+func (reg *AppRegistry) Select(aReq anyhttp.Request, username string) (*App, error) {
+	if reg == nil {
+		return nil, fmt.Errorf("no apps configured")
+	}
+	apps := reg.Apps()
+	path := GetPath(aReq)
+	for _, app := range apps {
+		if len(app.PathPrefix) > 0 && strings.HasPrefix(path, app.PathPrefix+"/") {
+			return app, nil
+		}
+	}
+	if name := strings.TrimSpace(GetHeader(aReq, reg.Header)); len(name) > 0 {
+		if app, ok := reg.Get(name); ok {
+			return app, nil
+		}
+		return nil, fmt.Errorf("app [%v] is not configured", name)
+	}
+	host := strings.ToLower(GetHost(aReq))
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	for _, app := range apps {
+		for _, try := range app.Hosts {
+			if host == try {
+				return app, nil
+			}
+		}
+	}
+	username = strings.TrimSpace(username)
+	for _, app := range apps {
+		if app.UsernamePattern != nil && len(username) > 0 && app.UsernamePattern.MatchString(username) {
+			return app, nil
+		}
+	}
+	if app, ok := reg.Get(reg.Default); ok {
+		return app, nil
+	}
+	return nil, fmt.Errorf("no app matches the request")
+}
+
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+	ro "github.com/grokify/oauth2more/ringcentral"
+)
+
+func newTestAppRegistry(t *testing.T) *AppRegistry {
+	reg := NewAppRegistry()
+	for _, app := range []*App{
+		{Name: "Default"},
+		{Name: "sandbox", PathPrefix: "sandbox/", Hosts: []string{"Sandbox.Example.com"}},
+		{Name: "eu", UsernamePattern: regexp.MustCompile(`^\+?44`)},
+	} {
+		if err := reg.Add(app); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return reg
+}
+
+func TestAppRegistrySelect(t *testing.T) {
+	reg := newTestAppRegistry(t)
+	tests := []struct {
+		url      string
+		header   string
+		username string
+		want     string
+		wantErr  bool
+	}{
+		{"http://proxy.example.com/ringout.asp", "", "16505550100", "default", false},
+		{"http://proxy.example.com/sandbox/ringout.asp", "", "16505550100", "sandbox", false},
+		// The path prefix must be a whole path segment.
+		{"http://proxy.example.com/sandboxes/ringout.asp", "", "", "default", false},
+		{"http://proxy.example.com/ringout.asp", "EU", "16505550100", "eu", false},
+		{"http://proxy.example.com/sandbox/ringout.asp", "eu", "", "sandbox", false},
+		{"http://proxy.example.com/ringout.asp", "missing", "", "", true},
+		{"http://sandbox.example.com:8080/ringout.asp", "", "", "sandbox", false},
+		{"http://proxy.example.com/ringout.asp", "", "+442071234567", "eu", false},
+		{"http://sandbox.example.com/ringout.asp", "", "442071234567", "sandbox", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		if len(tt.header) > 0 {
+			req.Header.Set(DefaultAppHeader, tt.header)
+		}
+		app, err := reg.Select(anyhttp.NewRequestNetHttp(req), tt.username)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("AppRegistry.Select(%v, %q): want error, got [%v]", tt.url, tt.header, app.Name)
+			}
+			continue
+		}
+		if err != nil || app.Name != tt.want {
+			t.Errorf("AppRegistry.Select(%v, %q, %q): want [%v], got [%v] %v", tt.url, tt.header, tt.username, tt.want, app, err)
+		}
+	}
+
+	reg.Default = "missing"
+	req := httptest.NewRequest(http.MethodGet, "http://proxy.example.com/ringout.asp", nil)
+	if _, err := reg.Select(anyhttp.NewRequestNetHttp(req), ""); err == nil {
+		t.Error("AppRegistry.Select without default app: want error")
+	}
+}
+
+func TestAppRegistryAdd(t *testing.T) {
+	reg := newTestAppRegistry(t)
+	if err := reg.Add(&App{Name: " SANDBOX "}); err == nil {
+		t.Error("AppRegistry.Add duplicate: want error")
+	}
+	if err := reg.Add(&App{Name: " "}); err == nil {
+		t.Error("AppRegistry.Add empty name: want error")
+	}
+	if app, ok := reg.Get("Sandbox"); !ok || app.PathPrefix != "/sandbox" || app.Hosts[0] != "sandbox.example.com" {
+		t.Errorf("AppRegistry.Get: want normalized sandbox app, got %+v", app)
+	}
+	if got := strings.Join(reg.Names(), ","); got != "eu,sandbox" {
+		t.Errorf("AppRegistry.Names: want [eu,sandbox], got [%v]", got)
+	}
+	if got := strings.Join(reg.PathPrefixes(), ","); got != "/sandbox" {
+		t.Errorf("AppRegistry.PathPrefixes: want [/sandbox], got [%v]", got)
+	}
+}
+
+func TestNewAppRegistryEnv(t *testing.T) {
+	env := map[string]string{
+		"RINGCENTRAL_SERVER_URL":                      "",
+		"RINGCENTRAL_CLIENT_ID":                       "",
+		"RINGCENTRAL_APPS":                            "sandbox, production",
+		"RINGCENTRAL_APP_SANDBOX_SERVER_URL":          "https://platform.devtest.example.com",
+		"RINGCENTRAL_APP_SANDBOX_CLIENT_ID":           "sandbox-id",
+		"RINGCENTRAL_APP_SANDBOX_HOSTS":               "sandbox.example.com, ",
+		"RINGCENTRAL_APP_SANDBOX_PATH_PREFIX":         "/sandbox",
+		"RINGCENTRAL_APP_PRODUCTION_SERVER_URL":       "https://platform.example.com",
+		"RINGCENTRAL_APP_PRODUCTION_CLIENT_ID":        "production-id",
+		"RINGCENTRAL_APP_PRODUCTION_CLIENT_SECRET":    "production-secret",
+		"RINGCENTRAL_APP_PRODUCTION_USERNAME_PATTERN": `^\+?1`,
+		"RINGCENTRAL_APP_DEFAULT":                     "Production",
+		"RINGCENTRAL_APP_HEADER":                      "X-App",
+	}
+	for name, value := range env {
+		os.Setenv(name, value)
+	}
+	defer func() {
+		for name := range env {
+			os.Unsetenv(name)
+		}
+	}()
+
+	reg, err := NewAppRegistryEnv()
+	if err != nil {
+		t.Fatalf("NewAppRegistryEnv: %v", err)
+	}
+	if _, ok := reg.Get(DefaultAppName); ok {
+		t.Error("default app added without RINGCENTRAL_SERVER_URL")
+	}
+	sandbox, _ := reg.Get("sandbox")
+	production, _ := reg.Get("production")
+	if sandbox == nil || production == nil || reg.Default != "production" || reg.Header != "X-App" {
+		t.Fatalf("NewAppRegistryEnv: got apps %v default [%v] header [%v]", reg.Names(), reg.Default, reg.Header)
+	}
+	if sandbox.Credentials.ClientID != "sandbox-id" || len(sandbox.Hosts) != 1 || production.UsernamePattern == nil {
+		t.Errorf("NewAppRegistryEnv: got sandbox %+v production %+v", sandbox, production)
+	}
+
+	// Validation errors use each app's setting names.
+	err = reg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "RINGCENTRAL_APP_SANDBOX_CLIENT_SECRET is not set") ||
+		strings.Contains(err.Error(), "RINGCENTRAL_APP_PRODUCTION_") {
+		t.Errorf("AppRegistry.Validate: got [%v]", err)
+	}
+	sandbox.Credentials = ro.ApplicationCredentials{ServerURL: "https://platform.devtest.example.com", ClientID: "id", ClientSecret: "secret"}
+	if err := reg.Validate(); err != nil {
+		t.Errorf("AppRegistry.Validate: %v", err)
+	}
+
+	os.Setenv("RINGCENTRAL_APP_PRODUCTION_USERNAME_PATTERN", "(")
+	if _, err := NewAppRegistryEnv(); err == nil || !strings.Contains(err.Error(), "RINGCENTRAL_APP_PRODUCTION_USERNAME_PATTERN") {
+		t.Errorf("NewAppRegistryEnv with invalid pattern: want error, got [%v]", err)
+	}
+}
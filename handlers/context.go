@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+)
+
+const DefaultUpstreamTimeout = 30 * time.Second
+
+// RequestContext returns the context for a request. The `net/http` context
+// is canceled when the client disconnects. The vendored `fasthttp` does not
+// provide a request context so a background context with the request's
+// `RequestLog` is used.
+func RequestContext(aReq anyhttp.Request) context.Context {
+	switch req := aReq.(type) {
+	case *anyhttp.RequestNetHttp:
+		return req.Raw.Context()
+	case *anyhttp.RequestFastHttp:
+		if reqLog, ok := req.Raw.UserValue(RequestLogUserValue).(*RequestLog); ok {
+			return WithRequestLog(context.Background(), reqLog)
+		}
+	}
+	return context.Background()
+}
+
+// IsTimeout returns true if the context deadline has been exceeded.
+func IsTimeout(ctx context.Context) bool {
+	return ctx.Err() == context.DeadlineExceeded
+}
+
+// HTTPClientWithContext returns a copy of `client` which sends all
+// requests with `ctx`, for helpers that do not accept a context.
+func HTTPClientWithContext(ctx context.Context, client *http.Client) *http.Client {
+	clientCopy := *client
+	clientCopy.Transport = contextTransport{ctx: ctx, transport: client.Transport}
+	return &clientCopy
+}
+
+type contextTransport struct {
+	ctx       context.Context
+	transport http.RoundTripper
+}
+
+func (t contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return transport.RoundTrip(req.WithContext(t.ctx))
+}
+
+// UpstreamTimeouts holds the upstream API timeout for each legacy command:
+// `list`, `call`, `status`, `cancel`, `faxout` and `faxstatus`.
+type UpstreamTimeouts map[string]time.Duration
+
+func NewUpstreamTimeouts() UpstreamTimeouts {
+	return UpstreamTimeouts{
+		"list":      15 * time.Second,
+		"call":      30 * time.Second,
+		"status":    10 * time.Second,
+		"cancel":    15 * time.Second,
+		"faxout":    120 * time.Second,
+		"faxstatus": 15 * time.Second}
+}
+
+// NewUpstreamTimeoutsEnv returns the default timeouts overridden by
+// `UPSTREAM_TIMEOUT_<COMMAND>` environment variables with duration
+// values such as `45s`.
+func NewUpstreamTimeoutsEnv() (UpstreamTimeouts, error) {
+	timeouts := NewUpstreamTimeouts()
+	for cmd := range timeouts {
+		name := "UPSTREAM_TIMEOUT_" + strings.ToUpper(cmd)
+		raw := strings.TrimSpace(os.Getenv(name))
+		if len(raw) == 0 {
+			continue
+		}
+		dur, err := time.ParseDuration(raw)
+		if err != nil || dur <= 0 {
+			return timeouts, fmt.Errorf("%v: invalid duration [%v]", name, raw)
+		}
+		timeouts[cmd] = dur
+	}
+	return timeouts, nil
+}
+
+// Get returns the timeout for a command or `DefaultUpstreamTimeout`.
+func (timeouts UpstreamTimeouts) Get(cmd string) time.Duration {
+	if dur, ok := timeouts[strings.ToLower(cmd)]; ok && dur > 0 {
+		return dur
+	}
+	return DefaultUpstreamTimeout
+}
+
+// WithTimeout returns a request context with the command timeout.
+func (timeouts UpstreamTimeouts) WithTimeout(aReq anyhttp.Request, cmd string) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(RequestContext(aReq), timeouts.Get(cmd))
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+)
+
+func TestNewUpstreamTimeoutsEnv(t *testing.T) {
+	tests := []struct {
+		env     map[string]string
+		cmd     string
+		want    time.Duration
+		wantErr bool
+	}{
+		{nil, "faxout", 120 * time.Second, false},
+		{nil, "CALL", 30 * time.Second, false},
+		{nil, "unknown", DefaultUpstreamTimeout, false},
+		{map[string]string{"UPSTREAM_TIMEOUT_STATUS": "45s"}, "status", 45 * time.Second, false},
+		{map[string]string{"UPSTREAM_TIMEOUT_STATUS": " 2m "}, "status", 2 * time.Minute, false},
+		{map[string]string{"UPSTREAM_TIMEOUT_STATUS": "45"}, "status", 0, true},
+		{map[string]string{"UPSTREAM_TIMEOUT_STATUS": "-1s"}, "status", 0, true},
+	}
+	for _, tt := range tests {
+		for name, value := range tt.env {
+			os.Setenv(name, value)
+		}
+		timeouts, err := NewUpstreamTimeoutsEnv()
+		for name := range tt.env {
+			os.Unsetenv(name)
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewUpstreamTimeoutsEnv(%v): want error [%v], got [%v]", tt.env, tt.wantErr, err)
+			continue
+		}
+		if !tt.wantErr && timeouts.Get(tt.cmd) != tt.want {
+			t.Errorf("UpstreamTimeouts.Get(%q) with %v: want [%v], got [%v]", tt.cmd, tt.env, tt.want, timeouts.Get(tt.cmd))
+		}
+	}
+}
+
+func TestUpstreamTimeoutsWithTimeout(t *testing.T) {
+	reqCtx, cancelReq := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/ringout.asp", nil).WithContext(reqCtx)
+	ctx, cancel := UpstreamTimeouts{"status": 20 * time.Millisecond}.WithTimeout(anyhttp.NewRequestNetHttp(req), "status")
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > 20*time.Millisecond {
+		t.Errorf("WithTimeout: want deadline within [20ms], got [%v %v]", deadline, ok)
+	}
+	<-ctx.Done()
+	if !IsTimeout(ctx) {
+		t.Errorf("IsTimeout after deadline: got [%v]", ctx.Err())
+	}
+
+	// The client disconnecting cancels without a timeout.
+	ctx, cancel = UpstreamTimeouts{}.WithTimeout(anyhttp.NewRequestNetHttp(req), "status")
+	defer cancel()
+	cancelReq()
+	<-ctx.Done()
+	if IsTimeout(ctx) {
+		t.Error("IsTimeout after client disconnect: want false")
+	}
+}
+
+func TestHTTPClientWithContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	client := HTTPClientWithContext(ctx, server.Client())
+	start := time.Now()
+	resp, err := client.Get(server.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("HTTPClientWithContext: want error after deadline")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("HTTPClientWithContext: returned after [%v]", elapsed)
+	}
+	if server.Client().Transport == client.Transport {
+		t.Error("HTTPClientWithContext: changed the original client")
+	}
+}
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
 	"mime/multipart"
 	"net/http"
 	"strconv"
@@ -22,10 +24,13 @@ const (
 
 type LegacyMultipartFormParser struct {
 	form *multipart.Form
+	args LegacyArgs
 }
 
 func NewLegacyMultipartFormParser(form *multipart.Form) LegacyMultipartFormParser {
-	return LegacyMultipartFormParser{form: form}
+	return LegacyMultipartFormParser{
+		form: form,
+		args: NewLegacyArgsMultipartForm(form)}
 }
 
 func (parser *LegacyMultipartFormParser) PasswordCredentials() ro.PasswordCredentials {
@@ -37,24 +42,22 @@ func (parser *LegacyMultipartFormParser) FaxRequest() ru.FaxRequest {
 }
 
 func (parser *LegacyMultipartFormParser) Format() string {
-	if vals, ok := parser.form.Value["Format"]; ok && len(vals) > 0 {
-		return strings.ToLower(strings.TrimSpace(vals[0]))
-	}
-	if vals, ok := parser.form.Value["format"]; ok && len(vals) > 0 {
-		return strings.ToLower(strings.TrimSpace(vals[0]))
-	}
-	return ""
+	return strings.ToLower(parser.args.GetString("Format"))
 }
 
+// NewPasswordCredentialsLegacyMultipartForm returns password credentials
+// for a legacy `Username` in the format `<phonenumber>[*<extension>]`.
+// The `Extension` field is used if no extension is in `Username`.
 func NewPasswordCredentialsLegacyMultipartForm(form *multipart.Form) ro.PasswordCredentials {
+	args := NewLegacyArgsMultipartForm(form)
 	var pwdCreds ro.PasswordCredentials
-	if vals, ok := form.Value["Username"]; ok && len(vals) > 0 {
-		pwdCreds.Username = strings.TrimSpace(vals[0])
+	if vals := args.GetStringSlice("Username"); len(vals) > 0 {
+		pwdCreds.Username, pwdCreds.Extension = ParseLegacyUsername(vals[0])
 	}
-	if vals, ok := form.Value["Extension"]; ok && len(vals) > 0 {
+	if vals := args.GetStringSlice("Extension"); len(vals) > 0 && len(pwdCreds.Extension) == 0 {
 		pwdCreds.Extension = strings.TrimSpace(vals[0])
 	}
-	if vals, ok := form.Value["Password"]; ok && len(vals) > 0 {
+	if vals := args.GetStringSlice("Password"); len(vals) > 0 {
 		pwdCreds.Password = strings.TrimSpace(vals[0])
 	}
 	return pwdCreds
@@ -65,8 +68,9 @@ func NewPasswordCredentialsLegacyMultipartForm(form *multipart.Form) ro.Password
 // https://github.com/golang/go/blob/master/src/net/http/request.go#L237
 // http://sanatgersappa.blogspot.com/2013/03/handling-multiple-file-uploads-in-go.html
 func NewFaxRequestLegacyMultipartForm(form *multipart.Form) ru.FaxRequest {
+	args := NewLegacyArgsMultipartForm(form)
 	fax := ru.NewFaxRequest()
-	if vals, ok := form.Value["Recipient"]; ok && len(vals) > 0 {
+	if vals := args.GetStringSlice("Recipient"); len(vals) > 0 {
 		for _, val := range vals {
 			val = strings.TrimSpace(val)
 			parts := strings.Split(val, "|")
@@ -75,21 +79,21 @@ func NewFaxRequestLegacyMultipartForm(form *multipart.Form) ru.FaxRequest {
 			}
 		}
 	}
-	if vals, ok := form.Value["Coverpage"]; ok && len(vals) > 0 {
+	if vals := args.GetStringSlice("Coverpage"); len(vals) > 0 {
 		for _, val := range vals {
 			if coverPage, err := ru.FaxCoverPageNameToIndex(val); err == nil {
 				fax.CoverIndex = int(coverPage)
 			}
 		}
 	}
-	if vals, ok := form.Value["Coverpagetext"]; ok && len(vals) > 0 {
+	if vals := args.GetStringSlice("Coverpagetext"); len(vals) > 0 {
 		for _, val := range vals {
 			if len(val) > 0 {
 				fax.CoverPageText = val
 			}
 		}
 	}
-	if vals, ok := form.Value["Resolution"]; ok && len(vals) > 0 {
+	if vals := args.GetStringSlice("Resolution"); len(vals) > 0 {
 		for _, val := range vals {
 			val = strings.ToLower(strings.TrimSpace(val))
 			if val == "high" || val == "low" {
@@ -98,7 +102,7 @@ func NewFaxRequestLegacyMultipartForm(form *multipart.Form) ru.FaxRequest {
 		}
 	}
 	// GMT time in format dd:mm:yy hh:mm
-	if vals, ok := form.Value["Sendtime"]; ok && len(vals) > 0 {
+	if vals := args.GetStringSlice("Sendtime"); len(vals) > 0 {
 		for _, val := range vals {
 			dt, err := tu.ParseFirst([]string{tu.DMYHM2, time.RFC3339}, val)
 			if err == nil {
@@ -106,46 +110,114 @@ func NewFaxRequestLegacyMultipartForm(form *multipart.Form) ru.FaxRequest {
 			}
 		}
 	}
-	if fileHeaders, ok := form.File["Attachment"]; ok {
+	if fileHeaders := MultipartFormFiles(form, "Attachment"); len(fileHeaders) > 0 {
 		fax.FileHeaders = fileHeaders
 	}
 	return fax
 }
 
-func WriteFaxAnyResponse(res anyhttp.Response, apiResp *http.Response, err error, format string) {
-	httpStatusCode := -1
-	legacyResponseCode := Successful
+// WriteFaxAnyResponse writes the response for a REST API fax request.
+// A `504` is returned if `ctx` has timed out.
+func WriteFaxAnyResponse(ctx context.Context, res anyhttp.Response, apiResp *http.Response, err error, format string) {
+	isJSON := strings.TrimSpace(strings.ToLower(format)) == "json"
 	if err != nil {
-		httpStatusCode = http.StatusInternalServerError
-		legacyResponseCode = GenericError
-	} else {
-		httpStatusCode = apiResp.StatusCode
-		if apiResp.StatusCode >= 500 {
-			legacyResponseCode = GenericError
-		} else if apiResp.StatusCode == 401 {
-			legacyResponseCode = AuthorizationFailed
-		} else if apiResp.StatusCode >= 300 {
-			legacyResponseCode = GenericError
+		statusCode := http.StatusInternalServerError
+		if IsTimeout(ctx) {
+			statusCode = http.StatusGatewayTimeout
+		}
+		if isJSON {
+			WriteErrorJson(res, statusCode, err)
+		} else {
+			writeFaxResponseCodeText(res, statusCode, GenericError)
 		}
+		return
 	}
 
-	res.SetStatusCode(httpStatusCode)
-	if strings.TrimSpace(strings.ToLower(format)) == "json" {
-		res.SetContentType(hum.ContentTypeAppJsonUtf8)
-		if err != nil {
-			resInfo := hum.ResponseInfo{
-				StatusCode: httpStatusCode,
-				Message:    err.Error()}
-			res.SetBodyBytes(resInfo.ToJson())
-		} else {
+	if apiResp.StatusCode < 300 {
+		if isJSON {
+			res.SetContentType(hum.ContentTypeAppJsonUtf8)
+			res.SetStatusCode(apiResp.StatusCode)
 			res.SetBodyStream(apiResp.Body, -1)
+		} else {
+			writeFaxResponseCodeText(res, apiResp.StatusCode, Successful)
 		}
+		return
+	}
+
+	if IsRateLimited(apiResp) {
+		WriteRateLimitedAnyResponse(res, format, apiResp)
+		return
+	}
+
+	restErr := ParseRestError(apiResp)
+	legacyResponseCode := FaxResponseCodeFromRestError(apiResp.StatusCode, restErr)
+	if isJSON {
+		resInfo := FaxResponseInfo{
+			StatusCode:   apiResp.StatusCode,
+			ResponseCode: legacyResponseCode,
+			ErrorCode:    restErr.ErrorCode,
+			Message:      RedactCredentials(restErr.Message)}
+		res.SetContentType(hum.ContentTypeAppJsonUtf8)
+		res.SetStatusCode(apiResp.StatusCode)
+		res.SetBodyBytes(resInfo.ToJson())
 	} else {
-		res.SetContentType(hum.ContentTypeTextPlainUsAscii)
-		res.SetBodyBytes([]byte(strconv.Itoa(int(legacyResponseCode))))
+		writeFaxResponseCodeText(res, apiResp.StatusCode, legacyResponseCode)
 	}
 }
 
+func writeFaxResponseCodeText(res anyhttp.Response, httpStatusCode int, code FaxResponseCode) {
+	res.SetContentType(hum.ContentTypeTextPlainUsAscii)
+	res.SetStatusCode(httpStatusCode)
+	res.SetBodyBytes([]byte(strconv.Itoa(int(code))))
+}
+
+// FaxResponseInfo is the `json` format error response which includes
+// the legacy response code and the REST API `errorCode`.
+type FaxResponseInfo struct {
+	StatusCode   int             `json:"statusCode"`
+	ResponseCode FaxResponseCode `json:"responseCode"`
+	ErrorCode    string          `json:"errorCode,omitempty"`
+	Message      string          `json:"body"`
+}
+
+func (resInfo *FaxResponseInfo) ToJson() []byte {
+	bytes, err := json.Marshal(resInfo)
+	if err != nil {
+		errInfo := hum.ResponseInfo{StatusCode: 500, Message: err.Error()}
+		return errInfo.ToJson()
+	}
+	return bytes
+}
+
+// RestErrorCodeToFaxResponseCode maps REST API `errorCode` values
+// to legacy response codes. Add entries to support more errors.
+var RestErrorCodeToFaxResponseCode = map[string]FaxResponseCode{
+	"FeatureNotAvailable":     FaxingProhibited,
+	"InsufficientPermissions": FaxingProhibited,
+	"CMN-408":                 FaxingProhibited, // Insufficient permissions
+	"InvalidToken":            AuthorizationFailed,
+	"TokenInvalid":            AuthorizationFailed,
+	"TokenExpired":            AuthorizationFailed,
+	"OAU-213":                 AuthorizationFailed, // Token not found
+}
+
+// FaxResponseCodeFromRestError returns the legacy response code for a REST API
+// error using `RestErrorCodeToFaxResponseCode` with a fallback to the HTTP status.
+func FaxResponseCodeFromRestError(statusCode int, restErr RestError) FaxResponseCode {
+	for _, errorCode := range restErr.ErrorCodes() {
+		if code, ok := RestErrorCodeToFaxResponseCode[errorCode]; ok {
+			return code
+		}
+	}
+	switch statusCode {
+	case http.StatusUnauthorized:
+		return AuthorizationFailed
+	case http.StatusForbidden:
+		return FaxingProhibited
+	}
+	return GenericError
+}
+
 type FaxResponseCode int
 
 const (
@@ -197,6 +269,38 @@ func FaxResponseCodeToResponseInfo(code FaxResponseCode) hum.ResponseInfo {
 	case NoFaxData:
 		return hum.ResponseInfo{StatusCode: http.StatusBadRequest, Message: "No fax data specified"}
 	default:
-		return hum.ResponseInfo{StatusCode: http.StatusBadRequest, Message: "No fax data specified"}
+		return hum.ResponseInfo{StatusCode: http.StatusInternalServerError, Message: "Generic error"}
+	}
+}
+
+// ValidateFaxRequest returns `NoFaxRecipients` or `NoFaxData` if
+// the request is missing recipients or attachments.
+func ValidateFaxRequest(fax ru.FaxRequest) FaxResponseCode {
+	hasRecipient := false
+	for _, to := range fax.To {
+		if len(strings.TrimSpace(to)) > 0 {
+			hasRecipient = true
+			break
+		}
+	}
+	if !hasRecipient {
+		return NoFaxRecipients
+	}
+	if len(fax.FileHeaders) == 0 && len(fax.FilePaths) == 0 {
+		return NoFaxData
+	}
+	return Successful
+}
+
+// WriteFaxResponseCode writes a legacy response code, or the
+// corresponding `hum.ResponseInfo` for the `json` format.
+func WriteFaxResponseCode(res anyhttp.Response, code FaxResponseCode, format string) {
+	resInfo := FaxResponseCodeToResponseInfo(code)
+	if strings.TrimSpace(strings.ToLower(format)) == "json" {
+		res.SetContentType(hum.ContentTypeAppJsonUtf8)
+		res.SetStatusCode(resInfo.StatusCode)
+		res.SetBodyBytes(resInfo.ToJson())
+	} else {
+		writeFaxResponseCodeText(res, resInfo.StatusCode, code)
 	}
 }
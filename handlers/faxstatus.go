@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+
+	rc "github.com/grokify/go-ringcentral/client"
+	"github.com/grokify/gotilla/net/anyhttp"
+	hum "github.com/grokify/gotilla/net/httputilmore"
+	ro "github.com/grokify/oauth2more/ringcentral"
+)
+
+// FaxStatusRequestParams represents the `faxstatus.asp` request parameters.
+// Parameters follow the FaxOut API and can be sent as query string,
+// URL encoded form or multipart form parameters.
+type FaxStatusRequestParams struct {
+	Username  string
+	Extension string
+	Password  string
+	MessageID string
+	Format    string
+}
+
+func NewFaxStatusRequestParamsFromAnyArgs(args anyhttp.Args) FaxStatusRequestParams {
+	legacyArgs := NewLegacyArgs(args)
+	params := FaxStatusRequestParams{
+		Password:  legacyArgs.GetString("Password"),
+		MessageID: legacyArgs.GetString("MessageID"),
+		Format:    strings.ToLower(legacyArgs.GetString("Format"))}
+	params.Username, params.Extension = ParseLegacyUsername(legacyArgs.GetString("Username"))
+	if len(params.Extension) == 0 {
+		params.Extension = legacyArgs.GetString("Extension")
+	}
+	return params
+}
+
+func NewFaxStatusRequestParamsFromMultipartForm(form *multipart.Form) FaxStatusRequestParams {
+	return NewFaxStatusRequestParamsFromAnyArgs(NewLegacyArgsMultipartForm(form))
+}
+
+func (params *FaxStatusRequestParams) PasswordCredentials() ro.PasswordCredentials {
+	return ro.PasswordCredentials{
+		Username:  params.Username,
+		Extension: params.Extension,
+		Password:  params.Password}
+}
+
+// FaxStatusInfo is the `json` format fax status response.
+type FaxStatusInfo struct {
+	MessageID     string               `json:"messageId"`
+	MessageStatus string               `json:"messageStatus"`
+	PageCount     int32                `json:"faxPageCount"`
+	Recipients    []FaxRecipientStatus `json:"recipients"`
+}
+
+type FaxRecipientStatus struct {
+	PhoneNumber   string `json:"phoneNumber"`
+	Name          string `json:"name,omitempty"`
+	MessageStatus string `json:"messageStatus"`
+	FaxErrorCode  string `json:"faxErrorCode,omitempty"`
+}
+
+func NewFaxStatusInfo(info rc.GetMessageInfoResponse) FaxStatusInfo {
+	status := FaxStatusInfo{
+		MessageID:     info.Id,
+		MessageStatus: info.MessageStatus,
+		PageCount:     info.FaxPageCount,
+		Recipients:    []FaxRecipientStatus{}}
+	for _, to := range info.To {
+		status.Recipients = append(status.Recipients, FaxRecipientStatus{
+			PhoneNumber:   to.PhoneNumber,
+			Name:          to.Name,
+			MessageStatus: to.MessageStatus,
+			FaxErrorCode:  to.FaxErrorCode})
+	}
+	return status
+}
+
+// LegacyString returns the status in the compact legacy-style format
+// `0 <MessageID> <status>;<recipient number>;<recipient status>[;...]`.
+func (status *FaxStatusInfo) LegacyString() string {
+	parts := []string{status.MessageStatus}
+	for _, recipient := range status.Recipients {
+		parts = append(parts, recipient.PhoneNumber, recipient.MessageStatus)
+	}
+	return fmt.Sprintf("%d %s %s", int(Successful), status.MessageID, strings.Join(parts, ";"))
+}
+
+func FaxStatusAnyResponse(ctx context.Context, aRes anyhttp.Response, apiClient *rc.APIClient, serverURL string, params FaxStatusRequestParams) {
+	// Message IDs exceed `int32` so the message-store endpoint is called
+	// directly rather than with `MessagesApi.LoadMessage`.
+	if _, err := strconv.ParseInt(params.MessageID, 10, 64); err != nil {
+		if params.Format == "json" {
+			anyhttp.WriteSimpleJson(aRes, http.StatusBadRequest,
+				fmt.Sprintf("Invalid MessageID [%v]", params.MessageID))
+		} else {
+			writeFaxResponseCodeText(aRes, http.StatusBadRequest, GenericError)
+		}
+		return
+	}
+	info, resp, err := LoadMessageInfo(
+		ctx, apiClient.HTTPClient(), serverURL, params.MessageID)
+	if err != nil {
+		if IsRateLimited(resp) {
+			WriteRateLimitedAnyResponse(aRes, params.Format, resp)
+			return
+		}
+		restErr := RestError{}
+		if apiErr, ok := err.(*RestAPIError); ok {
+			restErr = apiErr.RestError
+		}
+		statusCode := http.StatusInternalServerError
+		if IsTimeout(ctx) {
+			statusCode = http.StatusGatewayTimeout
+		} else if resp != nil {
+			statusCode = resp.StatusCode
+		}
+		if params.Format == "json" {
+			WriteErrorJson(aRes, statusCode, err)
+		} else {
+			writeFaxResponseCodeText(aRes, statusCode,
+				FaxResponseCodeFromRestError(statusCode, restErr))
+		}
+		return
+	}
+
+	status := NewFaxStatusInfo(info)
+	if params.Format == "json" {
+		bytes, err := json.Marshal(status)
+		if err != nil {
+			WriteErrorJson(aRes, http.StatusInternalServerError, err)
+			return
+		}
+		aRes.SetContentType(hum.ContentTypeAppJsonUtf8)
+		aRes.SetStatusCode(http.StatusOK)
+		aRes.SetBodyBytes(bytes)
+	} else {
+		aRes.SetContentType(hum.ContentTypeTextPlainUsAscii)
+		aRes.SetStatusCode(http.StatusOK)
+		aRes.SetBodyBytes([]byte(status.LegacyString()))
+	}
+}
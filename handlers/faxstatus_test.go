@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ru "github.com/grokify/go-ringcentral/clientutil"
+	"github.com/grokify/gotilla/net/anyhttp"
+)
+
+func TestFaxStatusAnyResponse(t *testing.T) {
+	server, _ := newFaxAPIServer(t, 0)
+	apiClient, err := ru.NewApiClientHttpClientBaseURL(server.Client(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		messageID  string
+		format     string
+		statusCode int
+		body       string
+	}{
+		{messageIDOverInt32, "", http.StatusOK, "0 " + messageIDOverInt32 + " Sent;+16505550100;Sent"},
+		{messageIDOverInt32, "json", http.StatusOK, `"messageId":"` + messageIDOverInt32 + `"`},
+		{"42", "json", http.StatusNotFound, ""},
+		{messageIDForbidden, "", http.StatusBadRequest, "2"},
+		{messageIDForbidden, "json", http.StatusBadRequest, "[ReadMessages] permission"},
+		{"abc", "", http.StatusBadRequest, "5"},
+		{"abc", "json", http.StatusBadRequest, "Invalid MessageID [abc]"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		FaxStatusAnyResponse(context.Background(), anyhttp.NewResponseNetHttp(rec),
+			apiClient, server.URL, FaxStatusRequestParams{MessageID: tt.messageID, Format: tt.format})
+		if rec.Code != tt.statusCode {
+			t.Errorf("FaxStatusAnyResponse(%q, %q): want status [%v], got [%v]",
+				tt.messageID, tt.format, tt.statusCode, rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), tt.body) {
+			t.Errorf("FaxStatusAnyResponse(%q, %q): want body containing [%v], got [%v]",
+				tt.messageID, tt.format, tt.body, rec.Body.String())
+		}
+	}
+}
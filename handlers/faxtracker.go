@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	rc "github.com/grokify/go-ringcentral/client"
+	hum "github.com/grokify/gotilla/net/httputilmore"
+	ro "github.com/grokify/oauth2more/ringcentral"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	DefaultFaxPollInterval   = 30 * time.Second
+	DefaultFaxMaxDuration    = 2 * time.Hour
+	DefaultFaxMaxTracked     = 1000
+	DefaultFaxRequestTimeout = 30 * time.Second
+)
+
+// FaxTracker polls the status of sent faxes and emails the final status
+// to the user, like the legacy FaxOut service. At most `MaxTracked`
+// faxes are tracked at once and each REST API call is bound by
+// `RequestTimeout`.
+type FaxTracker struct {
+	PollInterval   time.Duration
+	MaxDuration    time.Duration
+	MaxTracked     int
+	RequestTimeout time.Duration
+	Mailer         *SMTPMailer
+	mutex          sync.Mutex
+	tracked        int
+	stopping       bool
+	stop           chan struct{}
+	waitGroup      sync.WaitGroup
+}
+
+func NewFaxTracker(mailer *SMTPMailer) *FaxTracker {
+	return &FaxTracker{
+		PollInterval:   DefaultFaxPollInterval,
+		MaxDuration:    DefaultFaxMaxDuration,
+		MaxTracked:     DefaultFaxMaxTracked,
+		RequestTimeout: DefaultFaxRequestTimeout,
+		Mailer:         mailer,
+		stop:           make(chan struct{})}
+}
+
+// Track polls the message in the background until its status is final.
+// It returns an error if the message ID is invalid, `MaxTracked` faxes
+// are already tracked or the tracker is shutting down.
+func (tracker *FaxTracker) Track(apiClient *rc.APIClient, serverURL, messageID string) error {
+	if _, err := strconv.ParseInt(messageID, 10, 64); err != nil {
+		return fmt.Errorf("Invalid message ID [%v]", messageID)
+	}
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	if tracker.stopping {
+		return fmt.Errorf("Fax tracker is shutting down")
+	}
+	if tracker.MaxTracked > 0 && tracker.tracked >= tracker.MaxTracked {
+		return fmt.Errorf("Fax tracker is tracking the maximum %v faxes", tracker.MaxTracked)
+	}
+	tracker.tracked++
+	tracker.waitGroup.Add(1)
+	go func() {
+		defer func() {
+			tracker.mutex.Lock()
+			tracker.tracked--
+			tracker.mutex.Unlock()
+			tracker.waitGroup.Done()
+		}()
+		tracker.track(apiClient, serverURL, messageID)
+	}()
+	return nil
+}
+
+// Shutdown stops polling and waits for tracked faxes to be checked a
+// final time, emailing any final statuses, or for `ctx` to end.
+func (tracker *FaxTracker) Shutdown(ctx context.Context) error {
+	tracker.mutex.Lock()
+	if !tracker.stopping {
+		tracker.stopping = true
+		close(tracker.stop)
+	}
+	tracker.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		tracker.waitGroup.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (tracker *FaxTracker) track(apiClient *rc.APIClient, serverURL, messageID string) {
+	deadline := time.Now().Add(tracker.MaxDuration)
+	for stopping := false; !stopping && time.Now().Before(deadline); {
+		select {
+		case <-time.After(tracker.PollInterval):
+		case <-tracker.stop:
+			stopping = true
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), tracker.RequestTimeout)
+		info, _, err := LoadMessageInfo(ctx, apiClient.HTTPClient(), serverURL, messageID)
+		if err != nil {
+			cancel()
+			log.Warnf("FAX_TRACKER load message [%v] failed: %v", messageID, err)
+			continue
+		}
+		if IsFinalMessageStatus(info.MessageStatus) {
+			if err := tracker.notify(ctx, apiClient, info); err != nil {
+				log.Warnf("FAX_TRACKER email for message [%v] failed: %v", messageID, err)
+			}
+			cancel()
+			return
+		}
+		cancel()
+	}
+	log.Warnf("FAX_TRACKER message [%v] status not final when tracking stopped", messageID)
+}
+
+// LoadMessageInfo returns a message-store message. Message IDs are
+// larger than the `int32` the generated `MessagesApi.LoadMessage`
+// accepts so the REST API is called directly.
+func LoadMessageInfo(ctx context.Context, httpClient *http.Client, serverURL, messageID string) (rc.GetMessageInfoResponse, *http.Response, error) {
+	info := rc.GetMessageInfoResponse{}
+	if _, err := strconv.ParseInt(messageID, 10, 64); err != nil {
+		return info, nil, fmt.Errorf("Invalid MessageID [%v]", messageID)
+	}
+	req, err := http.NewRequest(http.MethodGet, ro.BuildURL(serverURL,
+		"account/~/extension/~/message-store/"+messageID, true, nil), nil)
+	if err != nil {
+		return info, nil, err
+	}
+	req.Header.Set("Accept", hum.ContentTypeAppJsonUtf8)
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return info, resp, err
+	}
+	if resp.StatusCode >= 300 {
+		restErr := ParseRestError(resp)
+		resp.Body.Close()
+		return info, resp, &RestAPIError{Status: resp.Status, RestError: restErr}
+	}
+	defer resp.Body.Close()
+	return info, resp, json.NewDecoder(resp.Body).Decode(&info)
+}
+
+func (tracker *FaxTracker) notify(ctx context.Context, apiClient *rc.APIClient, info rc.GetMessageInfoResponse) error {
+	ext, _, err := apiClient.UserSettingsApi.LoadExtensionInfo(ctx, "~", "~")
+	if err != nil {
+		return err
+	}
+	if ext.Contact == nil || len(strings.TrimSpace(ext.Contact.Email)) == 0 {
+		return fmt.Errorf("No email for extension [%v]", ext.Id)
+	}
+	subject, body := FaxStatusEmail(info)
+	return tracker.Mailer.Send([]string{strings.TrimSpace(ext.Contact.Email)}, subject, body)
+}
+
+// IsFinalMessageStatus returns true if a REST API `messageStatus`
+// will not change.
+func IsFinalMessageStatus(status string) bool {
+	switch strings.TrimSpace(status) {
+	case "", "Queued":
+		return false
+	}
+	return true
+}
+
+// FaxStatusEmail returns the plain-text subject and body
+// for a fax status notification.
+func FaxStatusEmail(info rc.GetMessageInfoResponse) (string, string) {
+	subject := fmt.Sprintf("Fax %s: %s", info.Id, info.MessageStatus)
+	lines := []string{
+		fmt.Sprintf("Fax message ID: %s", info.Id),
+		fmt.Sprintf("Status: %s", info.MessageStatus),
+		fmt.Sprintf("Pages: %d", info.FaxPageCount),
+		fmt.Sprintf("Created: %s", info.CreationTime.UTC().Format(time.RFC1123)),
+		"",
+		"Recipients:"}
+	for _, to := range info.To {
+		line := fmt.Sprintf("  %s", to.PhoneNumber)
+		if len(to.Name) > 0 {
+			line += fmt.Sprintf(" (%s)", to.Name)
+		}
+		line += fmt.Sprintf(": %s", to.MessageStatus)
+		if len(to.FaxErrorCode) > 0 {
+			line += fmt.Sprintf(" [%s]", to.FaxErrorCode)
+		}
+		lines = append(lines, line)
+	}
+	return subject, strings.Join(lines, "\n") + "\n"
+}
+
+// FaxResponseMessageID returns the message ID from a REST API fax
+// response. The response body is buffered so it can be read again.
+func FaxResponseMessageID(resp *http.Response) (string, error) {
+	if resp == nil || resp.Body == nil {
+		return "", fmt.Errorf("No fax response body")
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	faxResp := rc.FaxResponse{}
+	if err := json.Unmarshal(body, &faxResp); err != nil {
+		return "", err
+	}
+	if faxResp.Id == 0 {
+		return "", fmt.Errorf("No fax message ID")
+	}
+	return strconv.FormatInt(faxResp.Id, 10), nil
+}
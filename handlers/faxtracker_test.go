@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ru "github.com/grokify/go-ringcentral/clientutil"
+)
+
+// messageIDOverInt32 is a realistic message ID larger than `int32`.
+const messageIDOverInt32 = "1234567890123"
+
+// messageIDForbidden is a message ID the API server rejects
+// with a `CMN-408` error code.
+const messageIDForbidden = "43"
+
+// startSMTPStandIn starts a minimal SMTP server which sends the DATA of
+// each message it receives to the returned channel.
+func startSMTPStandIn(t *testing.T) (string, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	messages := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTPStandIn(conn, messages)
+		}
+	}()
+	return ln.Addr().String(), messages
+}
+
+func serveSMTPStandIn(conn net.Conn, messages chan<- string) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	fmt.Fprint(conn, "220 localhost SMTP stand-in\r\n")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			fmt.Fprint(conn, "250 localhost\r\n")
+		case strings.HasPrefix(cmd, "DATA"):
+			fmt.Fprint(conn, "354 end with .\r\n")
+			data := []string{}
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if strings.TrimRight(line, "\r\n") == "." {
+					break
+				}
+				data = append(data, strings.TrimRight(line, "\r\n"))
+			}
+			messages <- strings.Join(data, "\n")
+			fmt.Fprint(conn, "250 OK\r\n")
+		case strings.HasPrefix(cmd, "QUIT"):
+			fmt.Fprint(conn, "221 bye\r\n")
+			return
+		default:
+			fmt.Fprint(conn, "250 OK\r\n")
+		}
+	}
+}
+
+// newFaxAPIServer returns a REST API stand-in whose message is `Queued`
+// until `queuedPolls` polls have been made and then `Sent`.
+func newFaxAPIServer(t *testing.T, queuedPolls int32) (*httptest.Server, *int32) {
+	polls := new(int32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/message-store/"+messageIDOverInt32):
+			status := "Queued"
+			if atomic.AddInt32(polls, 1) > queuedPolls {
+				status = "Sent"
+			}
+			fmt.Fprintf(w, `{"id":"%s","messageStatus":"%s","faxPageCount":2,"to":[{"phoneNumber":"+16505550100","messageStatus":"%s"}]}`,
+				messageIDOverInt32, status, status)
+		case strings.HasSuffix(r.URL.Path, "/message-store/"+messageIDForbidden):
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"errorCode":"CMN-408","message":"In order to call this API endpoint, user needs to have [ReadMessages] permission"}`)
+		case strings.HasSuffix(r.URL.Path, "/extension/~"):
+			fmt.Fprint(w, `{"id":101,"contact":{"email":"user@example.com"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, polls
+}
+
+func TestFaxTrackerEmailsFinalStatus(t *testing.T) {
+	smtpAddr, messages := startSMTPStandIn(t)
+	server, _ := newFaxAPIServer(t, 1)
+	apiClient, err := ru.NewApiClientHttpClientBaseURL(server.Client(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracker := NewFaxTracker(&SMTPMailer{Addr: smtpAddr, From: "fax@example.com"})
+	tracker.PollInterval = 10 * time.Millisecond
+	if err := tracker.Track(apiClient, server.URL, messageIDOverInt32); err != nil {
+		t.Fatalf("FaxTracker.Track: %v", err)
+	}
+
+	select {
+	case message := <-messages:
+		for _, want := range []string{
+			"To: user@example.com",
+			"Subject: Fax " + messageIDOverInt32 + ": Sent",
+			"+16505550100: Sent"} {
+			if !strings.Contains(message, want) {
+				t.Errorf("email missing [%v]:\n%v", want, message)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email sent")
+	}
+	if err := tracker.Shutdown(context.Background()); err != nil {
+		t.Errorf("FaxTracker.Shutdown: %v", err)
+	}
+}
+
+func TestFaxTrackerShutdownChecksFinalStatus(t *testing.T) {
+	smtpAddr, messages := startSMTPStandIn(t)
+	server, polls := newFaxAPIServer(t, 0)
+	apiClient, err := ru.NewApiClientHttpClientBaseURL(server.Client(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracker := NewFaxTracker(&SMTPMailer{Addr: smtpAddr, From: "fax@example.com"})
+	tracker.PollInterval = time.Hour
+	tracker.MaxTracked = 1
+	if err := tracker.Track(apiClient, server.URL, messageIDOverInt32); err != nil {
+		t.Fatalf("FaxTracker.Track: %v", err)
+	}
+	if err := tracker.Track(apiClient, server.URL, messageIDOverInt32); err == nil {
+		t.Error("FaxTracker.Track over MaxTracked: want error")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracker.Shutdown(ctx); err != nil {
+		t.Fatalf("FaxTracker.Shutdown: %v", err)
+	}
+	if got := atomic.LoadInt32(polls); got != 1 {
+		t.Errorf("polls on shutdown: want [1], got [%v]", got)
+	}
+	select {
+	case <-messages:
+	default:
+		t.Error("no email sent on shutdown")
+	}
+	if err := tracker.Track(apiClient, server.URL, messageIDOverInt32); err == nil {
+		t.Error("FaxTracker.Track after Shutdown: want error")
+	}
+}
+
+func TestIsFinalMessageStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		final  bool
+	}{
+		{"", false},
+		{"Queued", false},
+		{" Queued ", false},
+		{"Sent", true},
+		{"SendingFailed", true},
+		{"Delivered", true},
+	}
+	for _, tt := range tests {
+		if got := IsFinalMessageStatus(tt.status); got != tt.final {
+			t.Errorf("IsFinalMessageStatus(%q): want [%v], got [%v]", tt.status, tt.final, got)
+		}
+	}
+}
+
+func TestLoadMessageInfoInvalidID(t *testing.T) {
+	for _, id := range []string{"", "abc", "12.5", "99999999999999999999"} {
+		if _, _, err := LoadMessageInfo(context.Background(), http.DefaultClient, "http://127.0.0.1:1", id); err == nil {
+			t.Errorf("LoadMessageInfo(%q): want error", id)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+package handlers
+
+// lockFile is a no-op on Windows, where writes are only serialized
+// within a process.
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
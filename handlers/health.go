@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+	hum "github.com/grokify/gotilla/net/httputilmore"
+	ro "github.com/grokify/oauth2more/ringcentral"
+)
+
+const (
+	DefaultUpstreamProbeInterval = 30 * time.Second
+	DefaultUpstreamProbeTimeout  = 5 * time.Second
+)
+
+// ValidateAppCredentials checks the application credentials are set and
+// the server URL is an absolute `http` or `https` URL.
+func ValidateAppCredentials(app ro.ApplicationCredentials) error {
+	return validateAppCredentials(app, "RINGCENTRAL_")
+}
+
+// validateAppCredentials uses `envPrefix` for the setting names in errors.
+func validateAppCredentials(app ro.ApplicationCredentials, envPrefix string) error {
+	problems := []string{}
+	serverURL, err := url.Parse(strings.TrimSpace(app.ServerURL))
+	if len(strings.TrimSpace(app.ServerURL)) == 0 {
+		problems = append(problems, envPrefix+"SERVER_URL is not set")
+	} else if err != nil || (serverURL.Scheme != "https" && serverURL.Scheme != "http") || len(serverURL.Host) == 0 {
+		problems = append(problems, envPrefix+"SERVER_URL is not an http or https URL")
+	}
+	for _, setting := range [][2]string{
+		{envPrefix + "CLIENT_ID", app.ClientID},
+		{envPrefix + "CLIENT_SECRET", app.ClientSecret}} {
+		name, val := setting[0], setting[1]
+		if len(val) == 0 {
+			problems = append(problems, name+" is not set")
+		} else if strings.TrimSpace(val) != val || strings.ContainsAny(val, " \t\r\n") {
+			problems = append(problems, name+" contains whitespace")
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%v", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// UpstreamProbe checks the REST API `/restapi/v1.0` endpoint, which does
+// not need authorization, is reachable. The result is cached for
+// `Interval` so frequent readiness probes do not call the REST API.
+type UpstreamProbe struct {
+	URL      string
+	Interval time.Duration
+	Client   *http.Client
+	mutex    sync.Mutex
+	checked  time.Time
+	err      error
+	running  chan struct{}
+}
+
+func NewUpstreamProbe(serverURL string, interval time.Duration) *UpstreamProbe {
+	if interval <= 0 {
+		interval = DefaultUpstreamProbeInterval
+	}
+	return &UpstreamProbe{
+		URL:      strings.TrimRight(strings.TrimSpace(serverURL), "/") + "/restapi/v1.0",
+		Interval: interval,
+		Client:   &http.Client{Timeout: DefaultUpstreamProbeTimeout}}
+}
+
+// Check returns the cached result or probes the REST API. The probe is
+// shared by concurrent callers and bound by `DefaultUpstreamProbeTimeout`
+// rather than `ctx`, so a caller which gives up does not cache its
+// cancellation as the result.
+func (probe *UpstreamProbe) Check(ctx context.Context) error {
+	probe.mutex.Lock()
+	if !probe.checked.IsZero() && time.Since(probe.checked) < probe.Interval {
+		err := probe.err
+		probe.mutex.Unlock()
+		return err
+	}
+	if probe.running == nil {
+		probe.running = make(chan struct{})
+		go probe.run(probe.running)
+	}
+	running := probe.running
+	probe.mutex.Unlock()
+
+	select {
+	case <-running:
+		probe.mutex.Lock()
+		defer probe.mutex.Unlock()
+		return probe.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (probe *UpstreamProbe) run(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultUpstreamProbeTimeout)
+	defer cancel()
+	err := probe.probe(ctx)
+	probe.mutex.Lock()
+	probe.err = err
+	probe.checked = time.Now()
+	probe.running = nil
+	probe.mutex.Unlock()
+	close(done)
+}
+
+func (probe *UpstreamProbe) probe(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, probe.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := probe.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("REST API not reachable: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("REST API status [%v]", resp.StatusCode)
+	}
+	return nil
+}
+
+// HealthChecker serves `/healthz` and `/readyz`. Readiness validates the
+// application credentials and, for apps with a probe in `Probes`, REST
+// API reachability.
+type HealthChecker struct {
+	Apps   *AppRegistry
+	Probes map[string]*UpstreamProbe
+}
+
+// ReadinessInfo is the `json` format readiness response.
+type ReadinessInfo struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+func (checker *HealthChecker) Readiness(ctx context.Context) ReadinessInfo {
+	info := ReadinessInfo{Ready: true, Checks: map[string]string{}}
+	if err := checker.Apps.Validate(); err != nil {
+		info.Ready = false
+		info.Checks["config"] = err.Error()
+	} else {
+		info.Checks["config"] = "OK"
+	}
+	if len(checker.Probes) > 0 {
+		problems := []string{}
+		for _, app := range checker.Apps.Apps() {
+			if probe, ok := checker.Probes[app.Name]; ok {
+				if err := probe.Check(ctx); err != nil {
+					problems = append(problems, app.Name+": "+RedactCredentials(err.Error()))
+				}
+			}
+		}
+		if len(problems) > 0 {
+			info.Ready = false
+			info.Checks["upstream"] = strings.Join(problems, "; ")
+		} else {
+			info.Checks["upstream"] = "OK"
+		}
+	}
+	return info
+}
+
+// LivenessAnyResponse writes `OK` if the process can serve requests.
+func (checker *HealthChecker) LivenessAnyResponse(aRes anyhttp.Response) {
+	aRes.SetContentType(hum.ContentTypeTextPlainUsAscii)
+	aRes.SetStatusCode(http.StatusOK)
+	aRes.SetBodyBytes([]byte("OK"))
+}
+
+// ReadinessAnyResponse writes `OK` if ready and a `503` with
+// `ERROR NotReady` if not. The `json` format includes each check.
+func (checker *HealthChecker) ReadinessAnyResponse(ctx context.Context, aRes anyhttp.Response, responseFormat string) {
+	info := checker.Readiness(ctx)
+	statusCode := http.StatusOK
+	if !info.Ready {
+		statusCode = http.StatusServiceUnavailable
+	}
+	if responseFormat == "json" {
+		bytes, err := json.Marshal(info)
+		if err != nil {
+			WriteErrorJson(aRes, http.StatusInternalServerError, err)
+			return
+		}
+		aRes.SetContentType(hum.ContentTypeAppJsonUtf8)
+		aRes.SetStatusCode(statusCode)
+		aRes.SetBodyBytes(bytes)
+		return
+	}
+	aRes.SetContentType(hum.ContentTypeTextPlainUsAscii)
+	aRes.SetStatusCode(statusCode)
+	if info.Ready {
+		aRes.SetBodyBytes([]byte("OK"))
+	} else {
+		aRes.SetBodyBytes([]byte("ERROR NotReady"))
+	}
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+	ro "github.com/grokify/oauth2more/ringcentral"
+)
+
+func TestValidateAppCredentials(t *testing.T) {
+	tests := []struct {
+		app      ro.ApplicationCredentials
+		problems []string
+	}{
+		{ro.ApplicationCredentials{ServerURL: "https://platform.ringcentral.com", ClientID: "id", ClientSecret: "secret"}, nil},
+		{ro.ApplicationCredentials{ServerURL: " http://localhost:8080 ", ClientID: "id", ClientSecret: "secret"}, nil},
+		{ro.ApplicationCredentials{}, []string{"SERVER_URL is not set", "CLIENT_ID is not set", "CLIENT_SECRET is not set"}},
+		{ro.ApplicationCredentials{ServerURL: "platform.ringcentral.com", ClientID: "id", ClientSecret: "secret"},
+			[]string{"SERVER_URL is not an http or https URL"}},
+		{ro.ApplicationCredentials{ServerURL: "ftp://platform.ringcentral.com", ClientID: "id", ClientSecret: "secret"},
+			[]string{"SERVER_URL is not an http or https URL"}},
+		{ro.ApplicationCredentials{ServerURL: "https://platform.ringcentral.com", ClientID: "id ", ClientSecret: "sec ret"},
+			[]string{"CLIENT_ID contains whitespace", "CLIENT_SECRET contains whitespace"}},
+	}
+	for _, tt := range tests {
+		err := ValidateAppCredentials(tt.app)
+		if (err != nil) != (len(tt.problems) > 0) {
+			t.Errorf("ValidateAppCredentials(%v): want problems %v, got [%v]", tt.app.ServerURL, tt.problems, err)
+			continue
+		}
+		for _, problem := range tt.problems {
+			if !strings.Contains(err.Error(), "RINGCENTRAL_"+problem) {
+				t.Errorf("ValidateAppCredentials(%v): want [%v], got [%v]", tt.app.ServerURL, problem, err)
+			}
+		}
+	}
+}
+
+func TestUpstreamProbeCachesResult(t *testing.T) {
+	probes := int32(0)
+	statusCode := int32(http.StatusOK)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&probes, 1)
+		if r.URL.Path != "/restapi/v1.0" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(int(atomic.LoadInt32(&statusCode)))
+	}))
+	defer server.Close()
+
+	probe := NewUpstreamProbe(server.URL+"/", 30*time.Millisecond)
+	tests := []struct {
+		statusCode int32
+		wait       time.Duration
+		wantErr    bool
+		wantProbes int32
+	}{
+		{http.StatusOK, 0, false, 1},
+		{http.StatusServiceUnavailable, 0, false, 1},
+		{http.StatusServiceUnavailable, 40 * time.Millisecond, true, 2},
+		{http.StatusOK, 0, true, 2},
+		{http.StatusOK, 40 * time.Millisecond, false, 3},
+	}
+	for i, tt := range tests {
+		atomic.StoreInt32(&statusCode, tt.statusCode)
+		time.Sleep(tt.wait)
+		err := probe.Check(context.Background())
+		if (err != nil) != tt.wantErr || atomic.LoadInt32(&probes) != tt.wantProbes {
+			t.Errorf("check %d: want error [%v] probes [%v], got [%v] [%v]", i, tt.wantErr, tt.wantProbes, err, probes)
+		}
+	}
+}
+
+func TestUpstreamProbeIgnoresCanceledCheck(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	probe := NewUpstreamProbe(server.URL, time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := probe.Check(ctx); err != context.Canceled {
+		t.Errorf("Check(canceled): want [%v], got [%v]", context.Canceled, err)
+	}
+	close(release)
+	if err := probe.Check(context.Background()); err != nil {
+		t.Errorf("Check: want [<nil>], got [%v]", err)
+	}
+}
+
+func TestHealthCheckerReadiness(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+
+	newChecker := func(serverURL, clientSecret string) *HealthChecker {
+		apps := NewAppRegistry()
+		apps.Add(&App{Name: DefaultAppName, EnvPrefix: "RINGCENTRAL_", Credentials: ro.ApplicationCredentials{
+			ServerURL: serverURL, ClientID: "id", ClientSecret: clientSecret}})
+		return &HealthChecker{Apps: apps, Probes: map[string]*UpstreamProbe{
+			DefaultAppName: NewUpstreamProbe(serverURL, time.Minute)}}
+	}
+	tests := []struct {
+		checker    *HealthChecker
+		format     string
+		statusCode int
+		body       string
+	}{
+		{newChecker(up.URL, "secret"), "", http.StatusOK, "OK"},
+		{newChecker(up.URL, "secret"), "json", http.StatusOK, `{"ready":true,"checks":{"config":"OK","upstream":"OK"}}`},
+		{newChecker(down.URL, "secret"), "", http.StatusServiceUnavailable, "ERROR NotReady"},
+		{newChecker(down.URL, "secret"), "json", http.StatusServiceUnavailable, `"upstream":"default: REST API status [502]"`},
+		{newChecker(up.URL, ""), "json", http.StatusServiceUnavailable, `"config":"RINGCENTRAL_CLIENT_SECRET is not set"`},
+		{&HealthChecker{Apps: NewAppRegistry()}, "json", http.StatusServiceUnavailable, `"config":"RINGCENTRAL_SERVER_URL is not set"`},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		tt.checker.ReadinessAnyResponse(context.Background(), anyhttp.NewResponseNetHttp(rec), tt.format)
+		if rec.Code != tt.statusCode || !strings.Contains(rec.Body.String(), tt.body) {
+			t.Errorf("ReadinessAnyResponse format [%v]: want [%v %v], got [%v %v]",
+				tt.format, tt.statusCode, tt.body, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	newChecker(down.URL, "").LivenessAnyResponse(anyhttp.NewResponseNetHttp(rec))
+	if rec.Code != http.StatusOK || rec.Body.String() != "OK" {
+		t.Errorf("LivenessAnyResponse: want [200 OK], got [%v %v]", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	IdempotencyKeyParam      = "idempotencykey"
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	DefaultIdempotencyWindow = 2 * time.Minute
+	// DefaultIdempotencyFingerprintWindow is short so an intentional
+	// redial of the same number is not mistaken for a retry.
+	DefaultIdempotencyFingerprintWindow = 15 * time.Second
+)
+
+// fingerprintKeyPrefix marks keys for requests without an idempotency
+// key, which use the `FingerprintWindow`.
+const fingerprintKeyPrefix = "fp:"
+
+// idempotencyScopeParams are included in explicit idempotency keys so
+// a key can only replay responses for the same user credentials.
+var idempotencyScopeParams = []string{"username", "extension", "ext", "password"}
+
+// RecordedResponse is a response recorded for replay.
+type RecordedResponse struct {
+	StatusCode  int
+	ContentType string
+	Header      http.Header
+	Body        []byte
+}
+
+// Write writes the recorded response.
+func (rec *RecordedResponse) Write(aRes anyhttp.Response) {
+	for key, vals := range rec.Header {
+		for _, val := range vals {
+			AddHeader(aRes, key, val)
+		}
+	}
+	if len(rec.ContentType) > 0 {
+		aRes.SetContentType(rec.ContentType)
+	}
+	aRes.SetStatusCode(rec.StatusCode)
+	aRes.SetBodyBytes(rec.Body)
+}
+
+// ResponseRecorder is an `anyhttp.Response` which writes to the wrapped
+// response and records it. `SetHeader`, `AddHeader` and `SetCookie`
+// headers are also recorded.
+type ResponseRecorder struct {
+	Response anyhttp.Response
+	Recorded *RecordedResponse
+}
+
+func NewResponseRecorder(aRes anyhttp.Response) *ResponseRecorder {
+	return &ResponseRecorder{
+		Response: aRes,
+		Recorded: &RecordedResponse{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{}}}
+}
+
+func (rec *ResponseRecorder) SetStatusCode(statusCode int) {
+	rec.Recorded.StatusCode = statusCode
+	rec.Response.SetStatusCode(statusCode)
+}
+
+func (rec *ResponseRecorder) SetContentType(contentType string) {
+	rec.Recorded.ContentType = contentType
+	rec.Response.SetContentType(contentType)
+}
+
+func (rec *ResponseRecorder) SetBodyBytes(body []byte) (int, error) {
+	rec.Recorded.Body = append([]byte{}, body...)
+	return rec.Response.SetBodyBytes(body)
+}
+
+func (rec *ResponseRecorder) SetBodyStream(bodyStream io.Reader, bodySize int) error {
+	body, err := ioutil.ReadAll(bodyStream)
+	if err != nil {
+		return err
+	}
+	_, err = rec.SetBodyBytes(body)
+	return err
+}
+
+// IdempotencyStore runs a request once per key within the window and
+// replays the first response to retries. Requests with the same key
+// which arrive while the first is in progress wait for its response.
+// Server errors and `429` responses are not stored so they can be retried.
+// Responses to requests matched by fingerprint are only replayed within
+// the shorter `FingerprintWindow`.
+type IdempotencyStore struct {
+	Window            time.Duration
+	Fingerprint       bool
+	FingerprintWindow time.Duration
+	// ScopeHeaders are request headers with user credentials, such as
+	// the API key header, which are part of every request key.
+	ScopeHeaders []string
+	mutex        sync.Mutex
+	salt         []byte
+	entries      map[string]*idempotencyEntry
+}
+
+type idempotencyEntry struct {
+	done     chan struct{}
+	response *RecordedResponse
+	expires  time.Time
+}
+
+// NewIdempotencyStore returns a store for the window. If `fingerprint`
+// is true, requests without an idempotency key are keyed by a hash of
+// their parameters and attachments.
+func NewIdempotencyStore(window time.Duration, fingerprint bool) (*IdempotencyStore, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if window <= 0 {
+		window = DefaultIdempotencyWindow
+	}
+	return &IdempotencyStore{
+		Window:            window,
+		Fingerprint:       fingerprint,
+		FingerprintWindow: DefaultIdempotencyFingerprintWindow,
+		salt:              salt,
+		entries:           map[string]*idempotencyEntry{}}, nil
+}
+
+// IdempotencyKey returns the `idempotencykey` parameter or
+// `Idempotency-Key` header value.
+func IdempotencyKey(aReq anyhttp.Request, args LegacyArgs) string {
+	if key := args.GetString(IdempotencyKeyParam); len(key) > 0 {
+		return key
+	}
+	return strings.TrimSpace(GetHeader(aReq, IdempotencyKeyHeader))
+}
+
+// RequestKey returns the store key for a request to the endpoint using
+// the request idempotency key or, if fingerprinting is enabled, a hash
+// of the parameters and files. An empty key means the request is not
+// deduplicated.
+func (store *IdempotencyStore) RequestKey(aReq anyhttp.Request, endpoint string, args LegacyArgs, files []*multipart.FileHeader) string {
+	if store == nil {
+		return ""
+	}
+	mac := hmac.New(sha256.New, store.salt)
+	writeHashField(mac, endpoint)
+	for _, header := range store.ScopeHeaders {
+		writeHashField(mac, header)
+		writeHashField(mac, GetHeader(aReq, header))
+	}
+	if idempotencyKey := IdempotencyKey(aReq, args); len(idempotencyKey) > 0 {
+		writeHashField(mac, "key")
+		writeHashField(mac, idempotencyKey)
+		for _, param := range idempotencyScopeParams {
+			writeHashField(mac, param)
+			for _, val := range args.GetStringSlice(param) {
+				writeHashField(mac, val)
+			}
+		}
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	if !store.Fingerprint || args.fallback != nil {
+		return ""
+	}
+	writeHashField(mac, "fingerprint")
+	params := []string{}
+	for param := range args.values {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+	for _, param := range params {
+		writeHashField(mac, param)
+		for _, val := range args.values[param] {
+			writeHashField(mac, val)
+		}
+	}
+	for _, fileHeader := range files {
+		writeHashField(mac, fileHeader.Filename)
+		writeHashField(mac, fileHeader.Header.Get("Content-Type"))
+		file, err := fileHeader.Open()
+		if err != nil {
+			return ""
+		}
+		_, err = io.Copy(mac, file)
+		file.Close()
+		if err != nil {
+			return ""
+		}
+		writeHashField(mac, "")
+	}
+	return fingerprintKeyPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// window returns how long the response for a key is replayed.
+func (store *IdempotencyStore) window(key string) time.Duration {
+	if strings.HasPrefix(key, fingerprintKeyPrefix) &&
+		store.FingerprintWindow > 0 && store.FingerprintWindow < store.Window {
+		return store.FingerprintWindow
+	}
+	return store.Window
+}
+
+func writeHashField(h hash.Hash, field string) {
+	h.Write([]byte(field))
+	h.Write([]byte{0})
+}
+
+// Do calls `fn` with a recording response if no response is stored for
+// the key and replays the stored response otherwise. If the key is empty
+// or the store is nil, `fn` is called with the response. If the context
+// ends while waiting for an in progress request, a `409` is written.
+func (store *IdempotencyStore) Do(ctx context.Context, aRes anyhttp.Response, key, responseFormat string, fn func(aRes anyhttp.Response)) {
+	if store == nil || len(key) == 0 {
+		fn(aRes)
+		return
+	}
+	for {
+		store.mutex.Lock()
+		store.purgeExpired()
+		entry, ok := store.entries[key]
+		if !ok {
+			entry = &idempotencyEntry{done: make(chan struct{})}
+			store.entries[key] = entry
+			store.mutex.Unlock()
+			store.record(aRes, key, entry, fn)
+			return
+		}
+		store.mutex.Unlock()
+
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			WriteLegacyErrorAnyResponse(aRes, http.StatusConflict, responseFormat, "RequestInProgress", ctx.Err())
+			return
+		}
+		if entry.response != nil {
+			RequestLogFromContext(ctx).Entry().WithFields(log.Fields{
+				"action": "idempotent_replay"}).Info("Replaying stored response.")
+			entry.response.Write(aRes)
+			return
+		}
+		// The first request was not stored so try again.
+	}
+}
+
+func (store *IdempotencyStore) record(aRes anyhttp.Response, key string, entry *idempotencyEntry, fn func(aRes anyhttp.Response)) {
+	rec := NewResponseRecorder(aRes)
+	completed := false
+	defer func() {
+		store.mutex.Lock()
+		defer store.mutex.Unlock()
+		statusCode := rec.Recorded.StatusCode
+		if completed && statusCode < 500 && statusCode != http.StatusTooManyRequests {
+			entry.response = rec.Recorded
+			entry.expires = time.Now().Add(store.window(key))
+		} else {
+			delete(store.entries, key)
+		}
+		close(entry.done)
+	}()
+	fn(rec)
+	completed = true
+}
+
+func (store *IdempotencyStore) purgeExpired() {
+	now := time.Now()
+	for key, entry := range store.entries {
+		if entry.response != nil && now.After(entry.expires) {
+			delete(store.entries, key)
+		}
+	}
+}
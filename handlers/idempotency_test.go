@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+)
+
+func TestIdempotencyStoreRequestKey(t *testing.T) {
+	store, err := NewIdempotencyStore(time.Minute, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := func(header string, values url.Values) string {
+		req := httptest.NewRequest(http.MethodPost, "/ringout.asp", nil)
+		if len(header) > 0 {
+			req.Header.Set(IdempotencyKeyHeader, header)
+		}
+		return store.RequestKey(anyhttp.NewRequestNetHttp(req), "ringout",
+			NewLegacyArgs(anyhttp.NewArgsUrlValues(values)), nil)
+	}
+	call := url.Values{"username": {"16505550100"}, "to": {"16505550101"}}
+	otherUser := url.Values{"username": {"16505550102"}, "to": {"16505550101"}}
+
+	tests := []struct {
+		name        string
+		a, b        string
+		same        bool
+		fingerprint bool
+	}{
+		{"fingerprint", key("", call), key("", call), true, true},
+		{"fingerprint differs", key("", call), key("", otherUser), false, true},
+		{"header key", key("k1", call), key("k1", call), true, false},
+		{"header key differs", key("k1", call), key("k2", call), false, false},
+		{"header key is scoped to user", key("k1", call), key("k1", otherUser), false, false},
+		{"param key", key("", url.Values{"to": {"1"}, "idempotencykey": {"k1"}}),
+			key("", url.Values{"to": {"2"}, "IdempotencyKey": {"k1"}}), true, false},
+	}
+	for _, tt := range tests {
+		if (tt.a == tt.b) != tt.same {
+			t.Errorf("%v: want same [%v], got [%v]", tt.name, tt.same, tt.a == tt.b)
+		}
+		if got := strings.HasPrefix(tt.a, fingerprintKeyPrefix); got != tt.fingerprint {
+			t.Errorf("%v: want fingerprint [%v], got [%v]", tt.name, tt.fingerprint, got)
+		}
+	}
+
+	store.Fingerprint = false
+	if got := key("", call); got != "" {
+		t.Errorf("fingerprint disabled: want no key, got [%v]", got)
+	}
+}
+
+func TestIdempotencyStoreReplayAndExpiry(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        string
+		statusCode int
+		wait       time.Duration
+		wantCalls  int
+	}{
+		{"replay", "key", http.StatusOK, 0, 1},
+		{"client error replayed", "key", http.StatusBadRequest, 0, 1},
+		{"server error not replayed", "key", http.StatusBadGateway, 0, 2},
+		{"rate limit not replayed", "key", http.StatusTooManyRequests, 0, 2},
+		{"expired", "key", http.StatusOK, 80 * time.Millisecond, 2},
+		{"fingerprint replay", fingerprintKeyPrefix + "key", http.StatusOK, 0, 1},
+		{"fingerprint expired", fingerprintKeyPrefix + "key", http.StatusOK, 30 * time.Millisecond, 2},
+		{"no key", "", http.StatusOK, 0, 2},
+	}
+	for _, tt := range tests {
+		store, err := NewIdempotencyStore(50*time.Millisecond, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		store.FingerprintWindow = 10 * time.Millisecond
+		calls := 0
+		do := func() *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			store.Do(context.Background(), anyhttp.NewResponseNetHttp(rec), tt.key, "", func(aRes anyhttp.Response) {
+				calls++
+				aRes.SetContentType("text/plain")
+				aRes.SetStatusCode(tt.statusCode)
+				aRes.SetBodyBytes([]byte(fmt.Sprintf("call %d", calls)))
+			})
+			return rec
+		}
+		first := do()
+		time.Sleep(tt.wait)
+		second := do()
+		if calls != tt.wantCalls {
+			t.Errorf("%v: want calls [%v], got [%v]", tt.name, tt.wantCalls, calls)
+		}
+		if tt.wantCalls == 1 && (second.Code != first.Code || second.Body.String() != first.Body.String()) {
+			t.Errorf("%v: want replay [%v %q], got [%v %q]", tt.name,
+				first.Code, first.Body.String(), second.Code, second.Body.String())
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"mime/multipart"
+	"sort"
+	"strings"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+	"github.com/valyala/fasthttp"
+)
+
+// LegacyArgs is a case-insensitive `anyhttp.Args` for legacy API parameters
+// which could be sent with any casing, e.g. `Username` or `username`. If a
+// parameter is sent with more than one casing, values are ordered by the
+// sorted parameter names so `Username` values come before `username` values.
+type LegacyArgs struct {
+	values   map[string][]string
+	fallback anyhttp.Args
+}
+
+type legacyArg struct {
+	key    string
+	values []string
+}
+
+// NewLegacyArgs returns a case-insensitive `LegacyArgs` for the `net/http`
+// and `fasthttp` `anyhttp.Args` implementations. Other implementations
+// are wrapped and looked up by the given, lower and title case names.
+func NewLegacyArgs(args anyhttp.Args) LegacyArgs {
+	switch raw := args.(type) {
+	case LegacyArgs:
+		return raw
+	case *anyhttp.ArgsUrlValues:
+		return newLegacyArgsMap(raw.Raw)
+	case anyhttp.ArgsUrlValues:
+		return newLegacyArgsMap(raw.Raw)
+	case *anyhttp.ArgsFastHttpMulti:
+		return newLegacyArgsFastHttp(raw.Raw...)
+	case anyhttp.ArgsFastHttpMulti:
+		return newLegacyArgsFastHttp(raw.Raw...)
+	case *anyhttp.ArgsFastHttp:
+		return newLegacyArgsFastHttp(raw.Raw)
+	case anyhttp.ArgsFastHttp:
+		return newLegacyArgsFastHttp(raw.Raw)
+	}
+	return LegacyArgs{fallback: args}
+}
+
+// NewLegacyArgsMultipartForm returns a case-insensitive `LegacyArgs`
+// for multipart form values.
+func NewLegacyArgsMultipartForm(form *multipart.Form) LegacyArgs {
+	if form == nil {
+		return newLegacyArgsMap(nil)
+	}
+	return newLegacyArgsMap(form.Value)
+}
+
+func newLegacyArgsMap(values map[string][]string) LegacyArgs {
+	args := []legacyArg{}
+	for key, vals := range values {
+		args = append(args, legacyArg{key: key, values: vals})
+	}
+	return newLegacyArgsSorted(args)
+}
+
+func newLegacyArgsFastHttp(rawArgs ...*fasthttp.Args) LegacyArgs {
+	args := []legacyArg{}
+	for _, raw := range rawArgs {
+		if raw == nil {
+			continue
+		}
+		raw.VisitAll(func(key, value []byte) {
+			args = append(args, legacyArg{
+				key:    string(key),
+				values: []string{string(value)}})
+		})
+	}
+	return newLegacyArgsSorted(args)
+}
+
+func newLegacyArgsSorted(args []legacyArg) LegacyArgs {
+	sort.SliceStable(args, func(i, j int) bool { return args[i].key < args[j].key })
+	values := map[string][]string{}
+	for _, arg := range args {
+		key := strings.ToLower(arg.key)
+		values[key] = append(values[key], arg.values...)
+	}
+	return LegacyArgs{values: values}
+}
+
+func (args LegacyArgs) GetStringSlice(key string) []string {
+	if args.fallback != nil {
+		// Some implementations return `[""]` for missing keys.
+		for _, try := range []string{key, strings.ToLower(key), strings.Title(strings.ToLower(key))} {
+			vals := args.fallback.GetStringSlice(try)
+			for _, val := range vals {
+				if len(val) > 0 {
+					return vals
+				}
+			}
+		}
+		return []string{}
+	}
+	if vals, ok := args.values[strings.ToLower(key)]; ok {
+		return vals
+	}
+	return []string{}
+}
+
+// GetString returns the first non-empty value with whitespace trimmed.
+func (args LegacyArgs) GetString(key string) string {
+	for _, val := range args.GetStringSlice(key) {
+		if val = strings.TrimSpace(val); len(val) > 0 {
+			return val
+		}
+	}
+	return ""
+}
+
+func (args LegacyArgs) GetBytes(key string) []byte { return []byte(args.GetString(key)) }
+
+func (args LegacyArgs) GetBytesSlice(key string) [][]byte {
+	slice := [][]byte{}
+	for _, val := range args.GetStringSlice(key) {
+		slice = append(slice, []byte(val))
+	}
+	return slice
+}
+
+// MultipartFormFiles returns the files for a case-insensitive form field
+// name, ordered by the sorted field names like `LegacyArgs`.
+func MultipartFormFiles(form *multipart.Form, key string) []*multipart.FileHeader {
+	files := []*multipart.FileHeader{}
+	if form == nil {
+		return files
+	}
+	keys := []string{}
+	for try := range form.File {
+		if strings.EqualFold(try, key) {
+			keys = append(keys, try)
+		}
+	}
+	sort.Strings(keys)
+	for _, try := range keys {
+		files = append(files, form.File[try]...)
+	}
+	return files
+}
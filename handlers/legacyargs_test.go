@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"mime/multipart"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+	"github.com/valyala/fasthttp"
+)
+
+func TestLegacyArgs(t *testing.T) {
+	values := url.Values{
+		"username": {"16505550101"},
+		"Username": {"16505550100"},
+		"CMD":      {"call"},
+		"to":       {"  ", " 16505550102 "},
+		"Format":   {"json"}}
+	fastArgs := &fasthttp.Args{}
+	fastArgs.Add("Username", "16505550100")
+	fastArgs.Add("username", "16505550101")
+	fastArgs.Add("CMD", "call")
+	fastArgs.Add("to", "  ")
+	fastArgs.Add("to", " 16505550102 ")
+	fastArgs.Add("Format", "json")
+
+	tests := []struct {
+		name string
+		args LegacyArgs
+	}{
+		{"url.Values", NewLegacyArgs(anyhttp.NewArgsUrlValues(values))},
+		{"fasthttp", NewLegacyArgs(anyhttp.NewArgsFastHttp(fastArgs))},
+		{"multipart", NewLegacyArgsMultipartForm(&multipart.Form{Value: values})},
+		{"LegacyArgs", NewLegacyArgs(NewLegacyArgs(anyhttp.NewArgsUrlValues(values)))},
+	}
+	for _, tt := range tests {
+		for key, want := range map[string]string{
+			"cmd":      "call",
+			"Cmd":      "call",
+			"CMD":      "call",
+			"format":   "json",
+			"to":       "16505550102",
+			"username": "16505550100",
+			"missing":  ""} {
+			if got := tt.args.GetString(key); got != want {
+				t.Errorf("%v: GetString(%q): want [%v], got [%v]", tt.name, key, want, got)
+			}
+		}
+		// `Username` sorts before `username`.
+		if got := tt.args.GetStringSlice("USERNAME"); !reflect.DeepEqual(got, []string{"16505550100", "16505550101"}) {
+			t.Errorf("%v: GetStringSlice(USERNAME): got %v", tt.name, got)
+		}
+		if got := tt.args.GetStringSlice("missing"); got == nil || len(got) != 0 {
+			t.Errorf("%v: GetStringSlice(missing): want empty slice, got %#v", tt.name, got)
+		}
+	}
+}
+
+func TestLegacyArgsFallback(t *testing.T) {
+	args := NewLegacyArgs(anyhttp.ArgsMapStringString{Raw: map[string]string{"Format": "json", "cmd": "list"}})
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"format", "json"},
+		{"Format", "json"},
+		{"CMD", "list"},
+		{"FORMAT", "json"},
+		{"missing", ""},
+	}
+	for _, tt := range tests {
+		if got := args.GetString(tt.key); got != tt.want {
+			t.Errorf("GetString(%q): want [%v], got [%v]", tt.key, tt.want, got)
+		}
+	}
+}
+
+func TestMultipartFormFiles(t *testing.T) {
+	form := &multipart.Form{File: map[string][]*multipart.FileHeader{
+		"attachment": {{Filename: "b.pdf"}},
+		"Attachment": {{Filename: "a.pdf"}},
+		"other":      {{Filename: "c.pdf"}}}}
+	tests := []struct {
+		form *multipart.Form
+		key  string
+		want []string
+	}{
+		{form, "ATTACHMENT", []string{"a.pdf", "b.pdf"}},
+		{form, "other", []string{"c.pdf"}},
+		{form, "missing", []string{}},
+		{nil, "attachment", []string{}},
+	}
+	for _, tt := range tests {
+		got := []string{}
+		for _, file := range MultipartFormFiles(tt.form, tt.key) {
+			got = append(got, file.Filename)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("MultipartFormFiles(%q): want %v, got %v", tt.key, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+)
+
+const MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultLatencyBuckets are the latency histogram buckets in seconds.
+var DefaultLatencyBuckets = []float64{
+	0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+var rxUpstreamAPIVersion = regexp.MustCompile(`^/restapi/v[0-9.]+`)
+
+// Metrics collects request, REST API and token cache metrics and writes
+// them in the Prometheus text exposition format. The vendored
+// dependencies do not include a Prometheus client so the format is
+// written directly. Methods can be called on nil.
+type Metrics struct {
+	Buckets          []float64
+	mutex            sync.Mutex
+	inFlight         int
+	requests         *counterVec
+	requestDuration  *histogramVec
+	upstream         *counterVec
+	upstreamDuration *histogramVec
+	tokenCache       *counterVec
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		Buckets: DefaultLatencyBuckets,
+		requests: newCounterVec("rclegacy_requests_total",
+			"Legacy API requests by route, command, HTTP status and legacy response code."),
+		requestDuration: newHistogramVec("rclegacy_request_duration_seconds",
+			"Legacy API request latency by route and command."),
+		upstream: newCounterVec("rclegacy_upstream_requests_total",
+			"REST API requests by API, method and HTTP status."),
+		upstreamDuration: newHistogramVec("rclegacy_upstream_request_duration_seconds",
+			"REST API request latency by API and method."),
+		tokenCache: newCounterVec("rclegacy_token_cache_lookups_total",
+			"Token cache lookups by result.")}
+}
+
+// RequestStarted increments the in-flight requests.
+func (m *Metrics) RequestStarted() {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.inFlight++
+}
+
+// RequestFinished decrements the in-flight requests and records the result.
+func (m *Metrics) RequestFinished(result RequestResult) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.inFlight--
+	m.requests.add(formatLabels(
+		"route", result.Route,
+		"cmd", result.Command,
+		"status", strconv.Itoa(result.StatusCode),
+		"code", result.LegacyCode), 1)
+	m.requestDuration.observe(formatLabels(
+		"route", result.Route,
+		"cmd", result.Command), result.Latency.Seconds(), m.Buckets)
+}
+
+// ObserveUpstream records a REST API response. A `statusCode` of 0
+// is recorded as `error`.
+func (m *Metrics) ObserveUpstream(method, path string, statusCode int, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	status := "error"
+	if statusCode > 0 {
+		status = strconv.Itoa(statusCode)
+	}
+	api := UpstreamAPIName(path)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.upstream.add(formatLabels("api", api, "method", method, "status", status), 1)
+	m.upstreamDuration.observe(formatLabels("api", api, "method", method), latency.Seconds(), m.Buckets)
+}
+
+// ObserveTokenCache records a token cache hit or miss.
+func (m *Metrics) ObserveTokenCache(hit bool) {
+	if m == nil {
+		return
+	}
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.tokenCache.add(formatLabels("result", result), 1)
+}
+
+// UpstreamAPIName returns the REST API path without the version prefix
+// and with numeric IDs replaced by `{id}` to limit label values.
+func UpstreamAPIName(path string) string {
+	path = rxUpstreamAPIVersion.ReplaceAllString(path, "")
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if rxDigits.MatchString(part) {
+			parts[i] = "{id}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// Write writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) Write(w io.Writer) error {
+	if m == nil {
+		return nil
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	buf := &bytes.Buffer{}
+	m.requests.write(buf)
+	m.requestDuration.write(buf)
+	writeMetricHeader(buf, "rclegacy_requests_in_flight", "gauge",
+		"Legacy API requests being handled.")
+	fmt.Fprintf(buf, "rclegacy_requests_in_flight %d\n", m.inFlight)
+	m.upstream.write(buf)
+	m.upstreamDuration.write(buf)
+	m.tokenCache.write(buf)
+	hits := m.tokenCache.values[formatLabels("result", "hit")]
+	misses := m.tokenCache.values[formatLabels("result", "miss")]
+	ratio := 0.0
+	if hits+misses > 0 {
+		ratio = hits / (hits + misses)
+	}
+	writeMetricHeader(buf, "rclegacy_token_cache_hit_ratio", "gauge",
+		"Ratio of token cache lookups which were hits.")
+	fmt.Fprintf(buf, "rclegacy_token_cache_hit_ratio %s\n", formatFloat(ratio))
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteAnyResponse writes the metrics for a `/metrics` request.
+func (m *Metrics) WriteAnyResponse(aRes anyhttp.Response) {
+	buf := &bytes.Buffer{}
+	m.Write(buf)
+	aRes.SetContentType(MetricsContentType)
+	aRes.SetStatusCode(200)
+	aRes.SetBodyBytes(buf.Bytes())
+}
+
+type counterVec struct {
+	name   string
+	help   string
+	values map[string]float64
+}
+
+func newCounterVec(name, help string) *counterVec {
+	return &counterVec{name: name, help: help, values: map[string]float64{}}
+}
+
+func (vec *counterVec) add(labels string, value float64) {
+	vec.values[labels] += value
+}
+
+func (vec *counterVec) write(w io.Writer) {
+	writeMetricHeader(w, vec.name, "counter", vec.help)
+	for _, labels := range sortedKeys(vec.values) {
+		fmt.Fprintf(w, "%s{%s} %s\n", vec.name, labels, formatFloat(vec.values[labels]))
+	}
+}
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+type histogramVec struct {
+	name   string
+	help   string
+	values map[string]*histogram
+}
+
+func newHistogramVec(name, help string) *histogramVec {
+	return &histogramVec{name: name, help: help, values: map[string]*histogram{}}
+}
+
+func (vec *histogramVec) observe(labels string, value float64, buckets []float64) {
+	hist, ok := vec.values[labels]
+	if !ok {
+		hist = &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+		vec.values[labels] = hist
+	}
+	for i, bound := range hist.buckets {
+		if value <= bound {
+			hist.counts[i]++
+		}
+	}
+	hist.count++
+	hist.sum += value
+}
+
+func (vec *histogramVec) write(w io.Writer) {
+	writeMetricHeader(w, vec.name, "histogram", vec.help)
+	labelsList := []string{}
+	for labels := range vec.values {
+		labelsList = append(labelsList, labels)
+	}
+	sort.Strings(labelsList)
+	for _, labels := range labelsList {
+		hist := vec.values[labels]
+		for i, bound := range hist.buckets {
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", vec.name, labels, formatFloat(bound), hist.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", vec.name, labels, hist.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", vec.name, labels, formatFloat(hist.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", vec.name, labels, hist.count)
+	}
+}
+
+func writeMetricHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// formatLabels formats label name and value pairs.
+func formatLabels(pairs ...string) string {
+	labels := []string{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, fmt.Sprintf("%s=%s", pairs[i], strconv.Quote(pairs[i+1])))
+	}
+	return strings.Join(labels, ",")
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
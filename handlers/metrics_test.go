@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+)
+
+func TestUpstreamAPIName(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/restapi/v1.0/account/~/extension/~/ring-out/1234", "/account/~/extension/~/ring-out/{id}"},
+		{"/restapi/v1.0/account/123/extension/456/fax", "/account/{id}/extension/{id}/fax"},
+		{"/restapi/oauth/token", "/restapi/oauth/token"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := UpstreamAPIName(tt.path); got != tt.want {
+			t.Errorf("UpstreamAPIName(%q): want [%v], got [%v]", tt.path, tt.want, got)
+		}
+	}
+}
+
+func TestMetricsWrite(t *testing.T) {
+	m := NewMetrics()
+	m.Buckets = []float64{0.1, 1}
+	m.RequestStarted()
+	m.RequestStarted()
+	m.RequestFinished(RequestResult{Route: "ringout", Command: "call", StatusCode: 200, LegacyCode: "OK", Latency: 50 * time.Millisecond})
+	m.ObserveUpstream(http.MethodPost, "/restapi/v1.0/account/~/extension/~/ring-out", 200, 500*time.Millisecond)
+	m.ObserveUpstream(http.MethodGet, "/restapi/v1.0/account/~/extension/~/ring-out/99", 0, 2*time.Second)
+	m.ObserveTokenCache(true)
+	m.ObserveTokenCache(true)
+	m.ObserveTokenCache(true)
+	m.ObserveTokenCache(false)
+
+	rec := httptest.NewRecorder()
+	m.WriteAnyResponse(anyhttp.NewResponseNetHttp(rec))
+	if rec.Header().Get("Content-Type") != MetricsContentType {
+		t.Errorf("Metrics.WriteAnyResponse: want content type [%v], got [%v]", MetricsContentType, rec.Header().Get("Content-Type"))
+	}
+	tests := []string{
+		"# TYPE rclegacy_requests_total counter",
+		`rclegacy_requests_total{route="ringout",cmd="call",status="200",code="OK"} 1`,
+		`rclegacy_request_duration_seconds_bucket{route="ringout",cmd="call",le="0.1"} 1`,
+		`rclegacy_request_duration_seconds_bucket{route="ringout",cmd="call",le="+Inf"} 1`,
+		`rclegacy_request_duration_seconds_sum{route="ringout",cmd="call"} 0.05`,
+		"rclegacy_requests_in_flight 1",
+		`rclegacy_upstream_requests_total{api="/account/~/extension/~/ring-out",method="POST",status="200"} 1`,
+		`rclegacy_upstream_requests_total{api="/account/~/extension/~/ring-out/{id}",method="GET",status="error"} 1`,
+		`rclegacy_upstream_request_duration_seconds_bucket{api="/account/~/extension/~/ring-out",method="POST",le="0.1"} 0`,
+		`rclegacy_upstream_request_duration_seconds_bucket{api="/account/~/extension/~/ring-out",method="POST",le="1"} 1`,
+		`rclegacy_upstream_request_duration_seconds_bucket{api="/account/~/extension/~/ring-out/{id}",method="GET",le="1"} 0`,
+		`rclegacy_token_cache_lookups_total{result="hit"} 3`,
+		"rclegacy_token_cache_hit_ratio 0.75",
+	}
+	for _, want := range tests {
+		if !strings.Contains(rec.Body.String(), want+"\n") {
+			t.Errorf("Metrics.Write: want line [%v]", want)
+		}
+	}
+}
+
+func TestMetricsNil(t *testing.T) {
+	var m *Metrics
+	m.RequestStarted()
+	m.RequestFinished(RequestResult{})
+	m.ObserveUpstream(http.MethodGet, "/", 200, time.Second)
+	m.ObserveTokenCache(true)
+	buf := &bytes.Buffer{}
+	if err := m.Write(buf); err != nil || buf.Len() != 0 {
+		t.Errorf("nil Metrics.Write: got [%v] %v", buf.String(), err)
+	}
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	rxNonDigits   = regexp.MustCompile(`\D`)
+	rxPhoneNumber = regexp.MustCompile(`^\+?[0-9()\-. ]*[0-9][0-9()\-. ]*$`)
+)
+
+// NormalizePhoneNumber converts a legacy phone number to full international
+// E.164 format. Legacy numbers include the country code and may omit the
+// leading `+`. 10-digit numbers are treated as North American numbers.
+// Values which are not phone numbers, such as email usernames, are
+// returned unchanged.
+func NormalizePhoneNumber(number string) string {
+	number = strings.TrimSpace(number)
+	if !rxPhoneNumber.MatchString(number) {
+		return number
+	}
+	digits := rxNonDigits.ReplaceAllString(number, "")
+	if len(digits) == 10 && !strings.HasPrefix(number, "+") {
+		return "+1" + digits
+	}
+	return "+" + digits
+}
+
+// ParseLegacyUsername splits a legacy `<phonenumber>[*<extension>]`
+// username into a normalized phone number and an extension.
+func ParseLegacyUsername(username string) (string, string) {
+	parts := strings.SplitN(strings.TrimSpace(username), "*", 2)
+	if len(parts) < 2 {
+		return NormalizePhoneNumber(parts[0]), ""
+	}
+	return NormalizePhoneNumber(parts[0]), strings.TrimSpace(parts[1])
+}
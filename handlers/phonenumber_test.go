@@ -0,0 +1,52 @@
+package handlers
+
+import "testing"
+
+func TestNormalizePhoneNumber(t *testing.T) {
+	tests := []struct {
+		number string
+		want   string
+	}{
+		{"16505550100", "+16505550100"},
+		{"+16505550100", "+16505550100"},
+		{" 6505550100 ", "+16505550100"},
+		{"(650) 555-0100", "+16505550100"},
+		{"1.650.555.0100", "+16505550100"},
+		{"+44 20 7946 0958", "+442079460958"},
+		{"442079460958", "+442079460958"},
+		{"", ""},
+		{"frontdesk@example.com", "frontdesk@example.com"},
+		{"user.name@example.co.uk", "user.name@example.co.uk"},
+		{"frontdesk", "frontdesk"},
+		{"650-FLOWERS", "650-FLOWERS"},
+	}
+	for _, tt := range tests {
+		if got := NormalizePhoneNumber(tt.number); got != tt.want {
+			t.Errorf("NormalizePhoneNumber(%q): want [%v], got [%v]", tt.number, tt.want, got)
+		}
+	}
+}
+
+func TestParseLegacyUsername(t *testing.T) {
+	tests := []struct {
+		username  string
+		number    string
+		extension string
+	}{
+		{"16505550100", "+16505550100", ""},
+		{"16505550100*101", "+16505550100", "101"},
+		{" 6505550100 * 101 ", "+16505550100", "101"},
+		{"16505550100*", "+16505550100", ""},
+		{"16505550100*101*2", "+16505550100", "101*2"},
+		{"frontdesk@example.com", "frontdesk@example.com", ""},
+		{"frontdesk@example.com*101", "frontdesk@example.com", "101"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		number, extension := ParseLegacyUsername(tt.username)
+		if number != tt.number || extension != tt.extension {
+			t.Errorf("ParseLegacyUsername(%q): want [%v %v], got [%v %v]",
+				tt.username, tt.number, tt.extension, number, extension)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateGroup is a RingCentral REST API rate limit group.
+type RateGroup string
+
+const (
+	RateGroupLight  RateGroup = "Light"
+	RateGroupMedium RateGroup = "Medium"
+	RateGroupHeavy  RateGroup = "Heavy"
+)
+
+// CommandRateGroups maps commands to the rate limit group of the
+// REST API endpoint they call.
+var CommandRateGroups = map[string]RateGroup{
+	"list":      RateGroupMedium,
+	"call":      RateGroupHeavy,
+	"status":    RateGroupLight,
+	"cancel":    RateGroupHeavy,
+	"faxout":    RateGroupHeavy,
+	"faxstatus": RateGroupLight}
+
+// RateLimit allows `Requests` per `Per` duration with bursts of up to
+// `Requests`.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseRateLimit parses `<requests>/<duration>` where duration is a
+// `time.Duration` or `s`, `m` or `h`, e.g. `10/m` or `100/30s`.
+func ParseRateLimit(s string) (RateLimit, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), "/", 2)
+	if len(parts) != 2 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit [%v]", s)
+	}
+	requests, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || requests <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit requests [%v]", s)
+	}
+	per := strings.TrimSpace(parts[1])
+	switch per {
+	case "s", "m", "h":
+		per = "1" + per
+	}
+	dur, err := time.ParseDuration(per)
+	if err != nil || dur <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit duration [%v]", s)
+	}
+	return RateLimit{Requests: requests, Per: dur}, nil
+}
+
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func (bucket *tokenBucket) refill(now time.Time) {
+	rate := float64(bucket.limit.Requests) / bucket.limit.Per.Seconds()
+	bucket.tokens = math.Min(float64(bucket.limit.Requests),
+		bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+	bucket.last = now
+}
+
+// wait returns the time until a token is available.
+func (bucket *tokenBucket) wait() time.Duration {
+	if bucket.tokens >= 1 {
+		return 0
+	}
+	rate := float64(bucket.limit.Requests) / bucket.limit.Per.Seconds()
+	return time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+}
+
+// RateLimiter applies token bucket limits per command keyed by user and
+// by client IP, and a global limit per REST API rate group. Commands
+// without a configured limit are not limited.
+type RateLimiter struct {
+	User              map[string]RateLimit
+	IP                map[string]RateLimit
+	Group             map[RateGroup]RateLimit
+	TrustForwardedFor bool
+	mutex             sync.Mutex
+	buckets           map[string]*tokenBucket
+	lastPurge         time.Time
+}
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		User:    map[string]RateLimit{},
+		IP:      map[string]RateLimit{},
+		Group:   map[RateGroup]RateLimit{},
+		buckets: map[string]*tokenBucket{}}
+}
+
+// NewRateLimiterEnv returns a `RateLimiter` configured by the
+// `RATE_LIMIT_USER_<COMMAND>`, `RATE_LIMIT_IP_<COMMAND>` and
+// `RATE_LIMIT_GROUP_<GROUP>` environment variables with
+// `ParseRateLimit` values. `RATE_LIMIT_TRUST_FORWARDED_FOR` uses
+// the `X-Forwarded-For` header for the client IP.
+func NewRateLimiterEnv() (*RateLimiter, error) {
+	limiter := NewRateLimiter()
+	limiter.TrustForwardedFor = strings.EqualFold(
+		strings.TrimSpace(os.Getenv("RATE_LIMIT_TRUST_FORWARDED_FOR")), "true")
+	for cmd, group := range CommandRateGroups {
+		for name, limits := range map[string]map[string]RateLimit{
+			"USER": limiter.User, "IP": limiter.IP} {
+			if limit, ok, err := rateLimitEnv("RATE_LIMIT_" + name + "_" + strings.ToUpper(cmd)); err != nil {
+				return nil, err
+			} else if ok {
+				limits[cmd] = limit
+			}
+		}
+		if limit, ok, err := rateLimitEnv("RATE_LIMIT_GROUP_" + strings.ToUpper(string(group))); err != nil {
+			return nil, err
+		} else if ok {
+			limiter.Group[group] = limit
+		}
+	}
+	return limiter, nil
+}
+
+func rateLimitEnv(name string) (RateLimit, bool, error) {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if len(raw) == 0 {
+		return RateLimit{}, false, nil
+	}
+	limit, err := ParseRateLimit(raw)
+	if err != nil {
+		return limit, false, fmt.Errorf("%v: %v", name, err)
+	}
+	return limit, true, nil
+}
+
+// Allow takes a token from each bucket which applies to the command,
+// user and client IP. If any bucket is empty, no tokens are taken and
+// the time until the request would be allowed is returned.
+func (limiter *RateLimiter) Allow(cmd, user, ip string) (bool, time.Duration) {
+	if limiter == nil {
+		return true, 0
+	}
+	cmd = strings.ToLower(cmd)
+	user = strings.ToLower(strings.TrimSpace(user))
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	now := time.Now()
+	limiter.purgeFull(now)
+
+	buckets := []*tokenBucket{}
+	if limit, ok := limiter.User[cmd]; ok && len(user) > 0 {
+		buckets = append(buckets, limiter.bucket("user\t"+cmd+"\t"+user, limit, now))
+	}
+	if limit, ok := limiter.IP[cmd]; ok && len(ip) > 0 {
+		buckets = append(buckets, limiter.bucket("ip\t"+cmd+"\t"+ip, limit, now))
+	}
+	if group, ok := CommandRateGroups[cmd]; ok {
+		if limit, ok := limiter.Group[group]; ok {
+			buckets = append(buckets, limiter.bucket("group\t"+string(group), limit, now))
+		}
+	}
+
+	wait := time.Duration(0)
+	for _, bucket := range buckets {
+		if bucketWait := bucket.wait(); bucketWait > wait {
+			wait = bucketWait
+		}
+	}
+	if wait > 0 {
+		return false, wait
+	}
+	for _, bucket := range buckets {
+		bucket.tokens--
+	}
+	return true, 0
+}
+
+func (limiter *RateLimiter) bucket(key string, limit RateLimit, now time.Time) *tokenBucket {
+	bucket, ok := limiter.buckets[key]
+	if !ok || bucket.limit != limit {
+		bucket = &tokenBucket{
+			limit:  limit,
+			tokens: float64(limit.Requests),
+			last:   now}
+		limiter.buckets[key] = bucket
+	}
+	bucket.refill(now)
+	return bucket
+}
+
+// purgeFull removes buckets which have refilled since they are the
+// same as new buckets.
+func (limiter *RateLimiter) purgeFull(now time.Time) {
+	if now.Sub(limiter.lastPurge) < time.Minute {
+		return
+	}
+	limiter.lastPurge = now
+	for key, bucket := range limiter.buckets {
+		bucket.refill(now)
+		if bucket.tokens >= float64(bucket.limit.Requests) {
+			delete(limiter.buckets, key)
+		}
+	}
+}
+
+// RetryAfterSecondsDuration rounds a wait up to whole seconds for
+// a `Retry-After` header.
+func RetryAfterSecondsDuration(wait time.Duration) int {
+	return int(math.Ceil(wait.Seconds()))
+}
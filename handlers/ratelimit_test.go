@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    RateLimit
+		wantErr bool
+	}{
+		{"10/m", RateLimit{10, time.Minute}, false},
+		{" 100 / 30s ", RateLimit{100, 30 * time.Second}, false},
+		{"5/s", RateLimit{5, time.Second}, false},
+		{"1000/h", RateLimit{1000, time.Hour}, false},
+		{"10", RateLimit{}, true},
+		{"0/m", RateLimit{}, true},
+		{"-1/m", RateLimit{}, true},
+		{"x/m", RateLimit{}, true},
+		{"10/0s", RateLimit{}, true},
+		{"10/day", RateLimit{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRateLimit(tt.s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseRateLimit(%q): want [%v] error [%v], got [%v] %v", tt.s, tt.want, tt.wantErr, got, err)
+		}
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	type request struct {
+		cmd, user, ip string
+		allowed       bool
+	}
+	tests := []struct {
+		name     string
+		limiter  func() *RateLimiter
+		requests []request
+	}{
+		{"nil limiter", func() *RateLimiter { return nil }, []request{
+			{"call", "16505550100", "10.0.0.1", true}}},
+		{"unconfigured command", NewRateLimiter, []request{
+			{"call", "16505550100", "10.0.0.1", true},
+			{"call", "16505550100", "10.0.0.1", true}}},
+		{"per user", func() *RateLimiter {
+			limiter := NewRateLimiter()
+			limiter.User["call"] = RateLimit{2, time.Hour}
+			return limiter
+		}, []request{
+			{"call", "16505550100", "10.0.0.1", true},
+			{"CALL", " 16505550100 ", "10.0.0.2", true},
+			{"call", "16505550100", "10.0.0.3", false},
+			{"call", "16505550101", "10.0.0.1", true},
+			{"list", "16505550100", "10.0.0.1", true}}},
+		{"per IP", func() *RateLimiter {
+			limiter := NewRateLimiter()
+			limiter.IP["faxout"] = RateLimit{1, time.Hour}
+			return limiter
+		}, []request{
+			{"faxout", "16505550100", "10.0.0.1", true},
+			{"faxout", "16505550101", "10.0.0.1", false},
+			{"faxout", "16505550100", "10.0.0.2", true}}},
+		{"per group", func() *RateLimiter {
+			limiter := NewRateLimiter()
+			limiter.Group[RateGroupHeavy] = RateLimit{2, time.Hour}
+			return limiter
+		}, []request{
+			{"call", "16505550100", "10.0.0.1", true},
+			{"faxout", "16505550101", "10.0.0.2", true},
+			{"cancel", "16505550102", "10.0.0.3", false},
+			{"status", "16505550100", "10.0.0.1", true}}},
+		{"denied requests take no tokens", func() *RateLimiter {
+			limiter := NewRateLimiter()
+			limiter.User["call"] = RateLimit{1, time.Hour}
+			limiter.Group[RateGroupHeavy] = RateLimit{2, time.Hour}
+			return limiter
+		}, []request{
+			{"call", "16505550100", "10.0.0.1", true},
+			{"call", "16505550100", "10.0.0.1", false},
+			{"call", "16505550100", "10.0.0.1", false},
+			{"call", "16505550101", "10.0.0.1", true}}},
+	}
+	for _, tt := range tests {
+		limiter := tt.limiter()
+		for i, req := range tt.requests {
+			allowed, wait := limiter.Allow(req.cmd, req.user, req.ip)
+			if allowed != req.allowed {
+				t.Errorf("%v: request %d: want allowed [%v], got [%v]", tt.name, i, req.allowed, allowed)
+			}
+			if allowed != (wait == 0) {
+				t.Errorf("%v: request %d: allowed [%v] with wait [%v]", tt.name, i, allowed, wait)
+			}
+		}
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	limiter := NewRateLimiter()
+	limiter.User["status"] = RateLimit{1, 50 * time.Millisecond}
+	if ok, _ := limiter.Allow("status", "16505550100", ""); !ok {
+		t.Fatal("first request not allowed")
+	}
+	ok, wait := limiter.Allow("status", "16505550100", "")
+	if ok || wait <= 0 || wait > 50*time.Millisecond {
+		t.Fatalf("second request: want wait in (0, 50ms], got allowed [%v] wait [%v]", ok, wait)
+	}
+	time.Sleep(wait + 5*time.Millisecond)
+	if ok, _ := limiter.Allow("status", "16505550100", ""); !ok {
+		t.Error("request after refill not allowed")
+	}
+}
+
+func TestRetryAfterSecondsDuration(t *testing.T) {
+	tests := []struct {
+		wait time.Duration
+		want int
+	}{
+		{0, 0},
+		{time.Millisecond, 1},
+		{time.Second, 1},
+		{1500 * time.Millisecond, 2},
+	}
+	for _, tt := range tests {
+		if got := RetryAfterSecondsDuration(tt.wait); got != tt.want {
+			t.Errorf("RetryAfterSecondsDuration(%v): want [%v], got [%v]", tt.wait, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+const Redacted = "[REDACTED]"
+
+var (
+	rxCredentialParam  = regexp.MustCompile(`(?i)((?:password|client_secret|access_token|refresh_token|assertion)=)[^&\s"']*`)
+	rxCredentialJSON   = regexp.MustCompile(`(?i)("(?:password|client_secret|access_token|refresh_token)"\s*:\s*")[^"]*`)
+	rxAuthorization    = regexp.MustCompile(`(?i)\b(Bearer|Basic)\s+[A-Za-z0-9\-._~+/]+=*`)
+	rxURLUserinfo      = regexp.MustCompile(`(://)[^/@\s]+@`)
+	rxAPIKey           = regexp.MustCompile(`\b(` + APIKeyPrefix + `[0-9a-f]+_)[A-Za-z0-9\-_]+`)
+	rxE164PhoneNumber  = regexp.MustCompile(`\+\d{7,15}\b`)
+	rxPhoneNumberDigit = regexp.MustCompile(`\d`)
+)
+
+// credentialLogFields are log field names, in lower case, whose values
+// are never logged.
+var credentialLogFields = map[string]bool{
+	"password":      true,
+	"client_secret": true,
+	"clientsecret":  true,
+	"access_token":  true,
+	"refresh_token": true,
+	"authorization": true,
+	"x-api-key":     true,
+	"jwt":           true}
+
+// phoneNumberLogFields are log field names, in lower case, whose values
+// are masked as phone numbers.
+var phoneNumberLogFields = map[string]bool{
+	"to":          true,
+	"from":        true,
+	"clid":        true,
+	"callerid":    true,
+	"username":    true,
+	"phonenumber": true,
+	"number":      true}
+
+// RedactCredentials removes passwords, tokens, client secrets and API
+// key secrets from text such as upstream error messages which can
+// include request URLs, form bodies or JSON.
+func RedactCredentials(s string) string {
+	s = rxCredentialParam.ReplaceAllString(s, "${1}"+Redacted)
+	s = rxCredentialJSON.ReplaceAllString(s, "${1}"+Redacted)
+	s = rxAuthorization.ReplaceAllString(s, "${1} "+Redacted)
+	s = rxAPIKey.ReplaceAllString(s, "${1}"+Redacted)
+	return rxURLUserinfo.ReplaceAllString(s, "${1}"+Redacted+"@")
+}
+
+// MaskPhoneNumber replaces all but the last four digits with `*`.
+func MaskPhoneNumber(s string) string {
+	digits := len(rxPhoneNumberDigit.FindAllString(s, -1))
+	return rxPhoneNumberDigit.ReplaceAllStringFunc(s, func(digit string) string {
+		digits--
+		if digits < 4 {
+			return digit
+		}
+		return "*"
+	})
+}
+
+// MaskPhoneNumbers masks E.164 phone numbers in text.
+func MaskPhoneNumbers(s string) string {
+	return rxE164PhoneNumber.ReplaceAllStringFunc(s, MaskPhoneNumber)
+}
+
+// WriteErrorJson writes an error with `anyhttp.WriteSimpleJson` with
+// credentials removed from the error text.
+func WriteErrorJson(aRes anyhttp.Response, statusCode int, err error) {
+	anyhttp.WriteSimpleJson(aRes, statusCode, RedactCredentials(err.Error()))
+}
+
+// RedactingFormatter is a logrus formatter which removes credentials
+// from messages and fields before formatting them with `Formatter`.
+// Phone numbers are masked to their last four digits if
+// `MaskPhoneNumbers` is true.
+type RedactingFormatter struct {
+	Formatter        log.Formatter
+	MaskPhoneNumbers bool
+}
+
+func (f *RedactingFormatter) Format(entry *log.Entry) ([]byte, error) {
+	redacted := *entry
+	redacted.Message = f.redactString(entry.Message)
+	redacted.Data = make(log.Fields, len(entry.Data))
+	for key, val := range entry.Data {
+		redacted.Data[key] = f.redactField(key, val)
+	}
+	formatter := f.Formatter
+	if formatter == nil {
+		formatter = &log.TextFormatter{}
+	}
+	return formatter.Format(&redacted)
+}
+
+func (f *RedactingFormatter) redactField(key string, val interface{}) interface{} {
+	key = strings.ToLower(key)
+	if credentialLogFields[key] {
+		return Redacted
+	}
+	var s string
+	switch v := val.(type) {
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	default:
+		return val
+	}
+	if f.MaskPhoneNumbers && phoneNumberLogFields[key] {
+		return MaskPhoneNumber(RedactCredentials(s))
+	}
+	return f.redactString(s)
+}
+
+func (f *RedactingFormatter) redactString(s string) string {
+	s = RedactCredentials(s)
+	if f.MaskPhoneNumbers {
+		s = MaskPhoneNumbers(s)
+	}
+	return s
+}
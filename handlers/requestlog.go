@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	RequestIDHeader = "X-Request-Id"
+	// RequestLogUserValue is the `fasthttp.RequestCtx` user value key
+	// for the `RequestLog` since `fasthttp` has no request context.
+	RequestLogUserValue = "requestLog"
+)
+
+type requestLogContextKey struct{}
+
+var rxRequestID = regexp.MustCompile(`^[A-Za-z0-9\-_.:=+/]{1,128}$`)
+
+// RequestLog holds the correlation ID and the values logged for a
+// request, including the last REST API response.
+type RequestLog struct {
+	ID                 string
+	Engine             string
+	mutex              sync.Mutex
+	start              time.Time
+	route              string
+	command            string
+	app                string
+	apiKeyID           string
+	statusCode         int
+	contentType        string
+	legacyCode         string
+	upstreamStatusCode int
+	upstreamRequestID  string
+	upstreamLatency    time.Duration
+}
+
+// NewRequestLog returns a `RequestLog` using the client `X-Request-Id`
+// if it is valid, or a new random ID.
+func NewRequestLog(requestID, engine string) *RequestLog {
+	if !rxRequestID.MatchString(requestID) {
+		requestID = NewRequestID()
+	}
+	return &RequestLog{
+		ID:     requestID,
+		Engine: engine,
+		start:  time.Now()}
+}
+
+func NewRequestID() string {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return hex.EncodeToString(bytes)
+}
+
+func WithRequestLog(ctx context.Context, reqLog *RequestLog) context.Context {
+	return context.WithValue(ctx, requestLogContextKey{}, reqLog)
+}
+
+// RequestLogFromContext returns the request's `RequestLog` or nil.
+// `RequestLog` methods can be called on nil.
+func RequestLogFromContext(ctx context.Context) *RequestLog {
+	if ctx == nil {
+		return nil
+	}
+	reqLog, _ := ctx.Value(requestLogContextKey{}).(*RequestLog)
+	return reqLog
+}
+
+func (reqLog *RequestLog) SetRoute(route string) {
+	if reqLog == nil {
+		return
+	}
+	reqLog.mutex.Lock()
+	defer reqLog.mutex.Unlock()
+	reqLog.route = route
+	reqLog.command = route
+}
+
+// SetApp sets the selected app name.
+func (reqLog *RequestLog) SetApp(app string) {
+	if reqLog == nil {
+		return
+	}
+	reqLog.mutex.Lock()
+	defer reqLog.mutex.Unlock()
+	reqLog.app = app
+}
+
+// SetAPIKeyID sets the ID of the API key used for the request.
+func (reqLog *RequestLog) SetAPIKeyID(id string) {
+	if reqLog == nil {
+		return
+	}
+	reqLog.mutex.Lock()
+	defer reqLog.mutex.Unlock()
+	reqLog.apiKeyID = id
+}
+
+// SetCommand sets the command, e.g. the RingOut `cmd`.
+func (reqLog *RequestLog) SetCommand(command string) {
+	if reqLog == nil {
+		return
+	}
+	reqLog.mutex.Lock()
+	defer reqLog.mutex.Unlock()
+	reqLog.command = command
+}
+
+func (reqLog *RequestLog) setStatusCode(statusCode int) {
+	reqLog.mutex.Lock()
+	defer reqLog.mutex.Unlock()
+	if reqLog.statusCode == 0 {
+		reqLog.statusCode = statusCode
+	}
+}
+
+// AddUpstream records a REST API response.
+func (reqLog *RequestLog) AddUpstream(statusCode int, requestID string, latency time.Duration) {
+	if reqLog == nil {
+		return
+	}
+	reqLog.mutex.Lock()
+	defer reqLog.mutex.Unlock()
+	reqLog.upstreamStatusCode = statusCode
+	reqLog.upstreamRequestID = requestID
+	reqLog.upstreamLatency += latency
+}
+
+// Fields returns the logrus fields for the request.
+func (reqLog *RequestLog) Fields() log.Fields {
+	if reqLog == nil {
+		return log.Fields{}
+	}
+	reqLog.mutex.Lock()
+	defer reqLog.mutex.Unlock()
+	fields := log.Fields{
+		"requestId": reqLog.ID,
+		"engine":    reqLog.Engine}
+	if len(reqLog.route) > 0 {
+		fields["route"] = reqLog.route
+		fields["cmd"] = reqLog.command
+	}
+	if len(reqLog.app) > 0 {
+		fields["app"] = reqLog.app
+	}
+	if len(reqLog.apiKeyID) > 0 {
+		fields["apiKeyId"] = reqLog.apiKeyID
+	}
+	if reqLog.upstreamStatusCode > 0 {
+		fields["upstreamStatus"] = reqLog.upstreamStatusCode
+		fields["upstreamRequestId"] = reqLog.upstreamRequestID
+		fields["upstreamLatency"] = reqLog.upstreamLatency.String()
+	}
+	return fields
+}
+
+// Entry returns a logrus entry with the request fields.
+func (reqLog *RequestLog) Entry() *log.Entry {
+	return log.WithFields(reqLog.Fields())
+}
+
+// RequestResult is the outcome of a request.
+type RequestResult struct {
+	Route      string
+	Command    string
+	StatusCode int
+	// LegacyCode is `OK`, `ERROR` or the numeric code starting a
+	// plain text response, `json` for JSON responses or `none`.
+	LegacyCode string
+	Latency    time.Duration
+}
+
+func (reqLog *RequestLog) Result() RequestResult {
+	if reqLog == nil {
+		return RequestResult{}
+	}
+	reqLog.mutex.Lock()
+	defer reqLog.mutex.Unlock()
+	result := RequestResult{
+		Route:      reqLog.route,
+		Command:    reqLog.command,
+		StatusCode: reqLog.statusCode,
+		LegacyCode: reqLog.legacyCode,
+		Latency:    time.Since(reqLog.start)}
+	if result.StatusCode == 0 {
+		result.StatusCode = http.StatusOK
+	}
+	if len(result.LegacyCode) == 0 {
+		result.LegacyCode = "none"
+		if strings.Contains(reqLog.contentType, "json") {
+			result.LegacyCode = "json"
+		}
+	}
+	return result
+}
+
+// LogEnd logs the response status and total latency.
+func (reqLog *RequestLog) LogEnd() {
+	if reqLog == nil {
+		return
+	}
+	result := reqLog.Result()
+	reqLog.Entry().WithFields(log.Fields{
+		"status":  result.StatusCode,
+		"code":    result.LegacyCode,
+		"latency": result.Latency.String()}).Info("END_HANDLE")
+}
+
+// Response returns `aRes` wrapped to record the status code.
+func (reqLog *RequestLog) Response(aRes anyhttp.Response) anyhttp.Response {
+	return &requestLogResponse{Response: aRes, reqLog: reqLog}
+}
+
+type requestLogResponse struct {
+	anyhttp.Response
+	reqLog *RequestLog
+}
+
+func (res *requestLogResponse) Unwrap() anyhttp.Response { return res.Response }
+
+func (res *requestLogResponse) SetStatusCode(statusCode int) {
+	res.reqLog.setStatusCode(statusCode)
+	res.Response.SetStatusCode(statusCode)
+}
+
+func (res *requestLogResponse) SetContentType(contentType string) {
+	res.reqLog.mutex.Lock()
+	res.reqLog.contentType = contentType
+	res.reqLog.mutex.Unlock()
+	res.Response.SetContentType(contentType)
+}
+
+func (res *requestLogResponse) SetBodyBytes(body []byte) (int, error) {
+	res.reqLog.mutex.Lock()
+	if strings.HasPrefix(res.reqLog.contentType, "text/plain") {
+		res.reqLog.legacyCode = legacyResponseCode(body)
+	}
+	res.reqLog.mutex.Unlock()
+	return res.Response.SetBodyBytes(body)
+}
+
+// legacyResponseCode returns the first word of a legacy plain text
+// response if it is `OK`, `ERROR` or a number.
+func legacyResponseCode(body []byte) string {
+	if len(body) > 32 {
+		body = body[:32]
+	}
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 {
+		return "none"
+	}
+	if fields[0] == "OK" || fields[0] == "ERROR" || rxDigits.MatchString(fields[0]) {
+		return fields[0]
+	}
+	return "other"
+}
+
+// upstreamLogTransport logs each REST API response with the request
+// fields of the request context and records it in `metrics`.
+type upstreamLogTransport struct {
+	Transport http.RoundTripper
+	metrics   *Metrics
+}
+
+func (t upstreamLogTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.Transport.RoundTrip(req)
+	latency := time.Since(start)
+	reqLog := RequestLogFromContext(req.Context())
+	fields := log.Fields{
+		"method":  req.Method,
+		"path":    req.URL.Path,
+		"latency": latency.String()}
+	if err != nil {
+		t.metrics.ObserveUpstream(req.Method, req.URL.Path, 0, latency)
+		reqLog.Entry().WithFields(fields).WithField("error", err).Warn("UPSTREAM_ERROR")
+		return resp, err
+	}
+	t.metrics.ObserveUpstream(req.Method, req.URL.Path, resp.StatusCode, latency)
+	restRequestID := resp.Header.Get(RequestIDHeader)
+	reqLog.AddUpstream(resp.StatusCode, restRequestID, latency)
+	fields["status"] = resp.StatusCode
+	fields["restRequestId"] = restRequestID
+	reqLog.Entry().WithFields(fields).Info("UPSTREAM_RESPONSE")
+	return resp, err
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+)
+
+func TestNewRequestLog(t *testing.T) {
+	tests := []struct {
+		requestID string
+		keep      bool
+	}{
+		{"abc-123", true},
+		{"5f0c:9a/b+c=", true},
+		{"", false},
+		{"has space", false},
+		{"line\nbreak", false},
+		{strings.Repeat("a", 129), false},
+	}
+	for _, tt := range tests {
+		reqLog := NewRequestLog(tt.requestID, "nethttp")
+		if got := reqLog.ID == tt.requestID; got != tt.keep {
+			t.Errorf("NewRequestLog(%q): want kept [%v], got ID [%v]", tt.requestID, tt.keep, reqLog.ID)
+		}
+		if !tt.keep && len(reqLog.ID) != 32 {
+			t.Errorf("NewRequestLog(%q): want new 32 character ID, got [%v]", tt.requestID, reqLog.ID)
+		}
+	}
+}
+
+func TestRequestLogResult(t *testing.T) {
+	tests := []struct {
+		contentType string
+		statusCodes []int
+		body        string
+		statusCode  int
+		legacyCode  string
+	}{
+		{"text/plain", nil, "OK 1234 5678", http.StatusOK, "OK"},
+		{"text/plain; charset=us-ascii", []int{http.StatusBadRequest}, "ERROR InvalidSessionID", http.StatusBadRequest, "ERROR"},
+		{"text/plain", []int{http.StatusOK}, "0 4567 Sent", http.StatusOK, "0"},
+		{"text/plain", []int{http.StatusOK}, "Hello", http.StatusOK, "other"},
+		{"text/plain", []int{http.StatusOK}, "", http.StatusOK, "none"},
+		{"application/json; charset=utf-8", []int{http.StatusCreated}, `{"OK":1}`, http.StatusCreated, "json"},
+		// The first status code written is logged.
+		{"text/html", []int{http.StatusNotFound, http.StatusOK}, "<html>", http.StatusNotFound, "none"},
+	}
+	for _, tt := range tests {
+		reqLog := NewRequestLog("", "nethttp")
+		reqLog.SetRoute("ringout")
+		reqLog.SetCommand("call")
+		aRes := reqLog.Response(anyhttp.NewResponseNetHttp(httptest.NewRecorder()))
+		aRes.SetContentType(tt.contentType)
+		for _, statusCode := range tt.statusCodes {
+			aRes.SetStatusCode(statusCode)
+		}
+		aRes.SetBodyBytes([]byte(tt.body))
+		result := reqLog.Result()
+		if result.StatusCode != tt.statusCode || result.LegacyCode != tt.legacyCode ||
+			result.Route != "ringout" || result.Command != "call" {
+			t.Errorf("RequestLog.Result(%q, %q): want [%v %v], got %+v",
+				tt.contentType, tt.body, tt.statusCode, tt.legacyCode, result)
+		}
+	}
+}
+
+func TestRequestLogFields(t *testing.T) {
+	var nilLog *RequestLog
+	nilLog.SetRoute("ringout")
+	nilLog.AddUpstream(http.StatusOK, "id", time.Second)
+	if got := nilLog.Fields(); len(got) != 0 {
+		t.Errorf("nil RequestLog.Fields: got %v", got)
+	}
+	if got := RequestLogFromContext(context.Background()); got != nil {
+		t.Errorf("RequestLogFromContext without RequestLog: got %v", got)
+	}
+
+	reqLog := NewRequestLog("req1", "fasthttp")
+	if got := RequestLogFromContext(WithRequestLog(context.Background(), reqLog)); got != reqLog {
+		t.Error("RequestLogFromContext: RequestLog not found")
+	}
+	reqLog.SetRoute("faxout")
+	reqLog.SetApp("sandbox")
+	reqLog.SetAPIKeyID("k1")
+	reqLog.AddUpstream(http.StatusOK, "up1", 10*time.Millisecond)
+	reqLog.AddUpstream(http.StatusCreated, "up2", 20*time.Millisecond)
+	fields := reqLog.Fields()
+	want := map[string]interface{}{
+		"requestId":         "req1",
+		"engine":            "fasthttp",
+		"route":             "faxout",
+		"cmd":               "faxout",
+		"app":               "sandbox",
+		"apiKeyId":          "k1",
+		"upstreamStatus":    http.StatusCreated,
+		"upstreamRequestId": "up2",
+		"upstreamLatency":   "30ms"}
+	for name, value := range want {
+		if fields[name] != value {
+			t.Errorf("RequestLog.Fields()[%v]: want [%v], got [%v]", name, value, fields[name])
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// RestError is a REST API error response body.
+type RestError struct {
+	ErrorCode string          `json:"errorCode,omitempty"`
+	Message   string          `json:"message,omitempty"`
+	Errors    []RestErrorInfo `json:"errors,omitempty"`
+}
+
+type RestErrorInfo struct {
+	ErrorCode string `json:"errorCode,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// RestAPIError is the error for a REST API error response.
+type RestAPIError struct {
+	Status    string
+	RestError RestError
+}
+
+func (err *RestAPIError) Error() string {
+	return fmt.Sprintf("%v %v", err.Status, err.RestError.Message)
+}
+
+// ParseRestError reads a REST API error response body. If the body
+// is not JSON, the raw body is used as the message.
+func ParseRestError(resp *http.Response) RestError {
+	restErr := RestError{}
+	if resp == nil || resp.Body == nil {
+		return restErr
+	}
+	bytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return restErr
+	}
+	if err := json.Unmarshal(bytes, &restErr); err != nil {
+		restErr.Message = strings.TrimSpace(string(bytes))
+	}
+	if len(restErr.Message) == 0 && len(restErr.Errors) > 0 {
+		restErr.Message = restErr.Errors[0].Message
+	}
+	if len(restErr.ErrorCode) == 0 && len(restErr.Errors) > 0 {
+		restErr.ErrorCode = restErr.Errors[0].ErrorCode
+	}
+	return restErr
+}
+
+// ErrorCodes returns the top-level `errorCode` followed by
+// the other `errorCode` values in `errors`.
+func (restErr RestError) ErrorCodes() []string {
+	codes := []string{}
+	seen := map[string]bool{"": true}
+	for _, code := range append([]string{restErr.ErrorCode}, restErr.errorInfoCodes()...) {
+		if !seen[code] {
+			seen[code] = true
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+func (restErr RestError) errorInfoCodes() []string {
+	codes := []string{}
+	for _, info := range restErr.Errors {
+		codes = append(codes, info.ErrorCode)
+	}
+	return codes
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseRestError(t *testing.T) {
+	tests := []struct {
+		body      string
+		errorCode string
+		message   string
+		codes     []string
+	}{
+		{`{"errorCode":"CMN-408","message":"In order to call this API endpoint, user needs to have [Faxes] permission"}`,
+			"CMN-408", "In order to call this API endpoint, user needs to have [Faxes] permission", []string{"CMN-408"}},
+		{`{"errors":[{"errorCode":"FeatureNotAvailable","message":"Feature not available"},{"errorCode":"CMN-101"}]}`,
+			"FeatureNotAvailable", "Feature not available", []string{"FeatureNotAvailable", "CMN-101"}},
+		{`{"errorCode":"InvalidToken","errors":[{"errorCode":"OAU-213","message":"Token not found"}]}`,
+			"InvalidToken", "Token not found", []string{"InvalidToken", "OAU-213"}},
+		{"Bad Gateway\n", "", "Bad Gateway", []string{}},
+		{"", "", "", []string{}},
+	}
+	for _, tt := range tests {
+		restErr := ParseRestError(&http.Response{Body: ioutil.NopCloser(strings.NewReader(tt.body))})
+		if restErr.ErrorCode != tt.errorCode || restErr.Message != tt.message {
+			t.Errorf("ParseRestError(%q): want [%v %v], got [%v %v]",
+				tt.body, tt.errorCode, tt.message, restErr.ErrorCode, restErr.Message)
+		}
+		if got := restErr.ErrorCodes(); !reflect.DeepEqual(got, tt.codes) {
+			t.Errorf("RestError.ErrorCodes(%q): want %v, got %v", tt.body, tt.codes, got)
+		}
+	}
+	if restErr := ParseRestError(nil); restErr.ErrorCode != "" || restErr.Message != "" {
+		t.Errorf("ParseRestError(nil): got %+v", restErr)
+	}
+}
+
+func TestFaxResponseCodeFromRestError(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		errorCodes []string
+		want       FaxResponseCode
+	}{
+		{http.StatusForbidden, []string{"CMN-408"}, FaxingProhibited},
+		{http.StatusBadRequest, []string{"FeatureNotAvailable"}, FaxingProhibited},
+		{http.StatusUnauthorized, []string{"OAU-213"}, AuthorizationFailed},
+		{http.StatusBadRequest, []string{"CMN-101", "TokenExpired"}, AuthorizationFailed},
+		{http.StatusUnauthorized, nil, AuthorizationFailed},
+		{http.StatusForbidden, []string{"CMN-101"}, FaxingProhibited},
+		{http.StatusBadRequest, []string{"CMN-101"}, GenericError},
+		{http.StatusInternalServerError, nil, GenericError},
+	}
+	for _, tt := range tests {
+		restErr := RestError{}
+		for _, code := range tt.errorCodes {
+			restErr.Errors = append(restErr.Errors, RestErrorInfo{ErrorCode: code})
+		}
+		if got := FaxResponseCodeFromRestError(tt.statusCode, restErr); got != tt.want {
+			t.Errorf("FaxResponseCodeFromRestError(%v, %v): want [%v], got [%v]", tt.statusCode, tt.errorCodes, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+	hum "github.com/grokify/gotilla/net/httputilmore"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	DefaultMaxRetries     = 3
+	DefaultRetryBaseDelay = 500 * time.Millisecond
+	DefaultRetryMaxDelay  = 10 * time.Second
+)
+
+// RetryTransport retries idempotent (`GET` and `HEAD`) requests which
+// receive a `429` response. The wait uses the `Retry-After` and
+// `X-Rate-Limit-Window` headers, falling back to exponential backoff
+// with jitter. Requests are not retried past the request context deadline.
+type RetryTransport struct {
+	Transport  http.RoundTripper
+	MaxRetries int
+	BaseDelay  time.Duration
+	MaxDelay   time.Duration
+}
+
+func NewRetryTransport(transport http.RoundTripper) RetryTransport {
+	return RetryTransport{
+		Transport:  transport,
+		MaxRetries: DefaultMaxRetries,
+		BaseDelay:  DefaultRetryBaseDelay,
+		MaxDelay:   DefaultRetryMaxDelay}
+}
+
+func (t RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	idempotent := req.Method == http.MethodGet || req.Method == http.MethodHead
+	for attempt := 0; ; attempt++ {
+		resp, err := transport.RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests ||
+			!idempotent || attempt >= t.MaxRetries {
+			return resp, err
+		}
+		wait := t.retryDelay(resp, attempt)
+		if deadline, ok := req.Context().Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return resp, err
+		}
+		RequestLogFromContext(req.Context()).Entry().WithFields(log.Fields{
+			"action":  "upstream_rate_limited_retry",
+			"url":     req.URL.Path,
+			"attempt": attempt + 1,
+			"wait":    wait.String()}).Info("Retrying rate limited request.")
+		resp.Body.Close()
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (t RetryTransport) retryDelay(resp *http.Response, attempt int) time.Duration {
+	if seconds := RetryAfterSeconds(resp); seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	backoff := t.BaseDelay << uint(attempt)
+	if backoff <= 0 || backoff > t.MaxDelay {
+		backoff = t.MaxDelay
+	}
+	// Full jitter so concurrent requests do not retry together.
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// RetryAfterSeconds returns the seconds to wait from the `Retry-After`
+// or `X-Rate-Limit-Window` response headers, or 0 if not set.
+func RetryAfterSeconds(resp *http.Response) int {
+	if resp == nil {
+		return 0
+	}
+	rlstat := hum.NewResponseRateLimitInfo(resp, true)
+	if rlstat.RetryAfter > 0 {
+		return rlstat.RetryAfter
+	}
+	if retryAt, err := http.ParseTime(resp.Header.Get("Retry-After")); err == nil {
+		if seconds := int(time.Until(retryAt).Seconds()); seconds > 0 {
+			return seconds
+		}
+	}
+	return rlstat.XRateLimitWindow
+}
+
+// IsRateLimited returns true for a `429` response.
+func IsRateLimited(resp *http.Response) bool {
+	return resp != nil && resp.StatusCode == http.StatusTooManyRequests
+}
+
+// WriteRateLimitedAnyResponse writes a `429` with a `Retry-After` header.
+// The legacy format is `ERROR RateLimited <seconds>`.
+func WriteRateLimitedAnyResponse(aRes anyhttp.Response, responseFormat string, resp *http.Response) {
+	WriteRetryAfterAnyResponse(aRes, responseFormat, RetryAfterSeconds(resp))
+}
+
+// WriteRetryAfterAnyResponse writes a `429` asking the client to retry
+// after the given seconds.
+func WriteRetryAfterAnyResponse(aRes anyhttp.Response, responseFormat string, seconds int) {
+	SetHeader(aRes, "Retry-After", strconv.Itoa(seconds))
+	if responseFormat == "json" {
+		anyhttp.WriteSimpleJson(aRes, http.StatusTooManyRequests,
+			fmt.Sprintf("Rate limited, retry after %d seconds", seconds))
+		return
+	}
+	WriteLegacyErrorAnyResponse(aRes, http.StatusTooManyRequests, responseFormat,
+		fmt.Sprintf("RateLimited %d", seconds), nil)
+}
+
+// WriteUpstreamErrorAnyResponse writes timeout and rate limit errors for a
+// failed REST API call. It returns false if the error is neither so the
+// caller can write its own error.
+func WriteUpstreamErrorAnyResponse(ctx context.Context, aRes anyhttp.Response, resp *http.Response, responseFormat string, err error) bool {
+	if IsTimeout(ctx) {
+		WriteLegacyErrorAnyResponse(aRes, http.StatusGatewayTimeout, responseFormat, "Timeout", err)
+		return true
+	}
+	if IsRateLimited(resp) {
+		WriteRateLimitedAnyResponse(aRes, responseFormat, resp)
+		return true
+	}
+	return false
+}
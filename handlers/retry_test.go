@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+)
+
+func TestRetryAfterSeconds(t *testing.T) {
+	tests := []struct {
+		headers map[string]string
+		want    int
+	}{
+		{map[string]string{}, 0},
+		{map[string]string{"Retry-After": "7"}, 7},
+		{map[string]string{"X-Rate-Limit-Window": "60"}, 60},
+		{map[string]string{"Retry-After": "7", "X-Rate-Limit-Window": "60"}, 7},
+		{map[string]string{"Retry-After": time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)}, 59},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+		for name, value := range tt.headers {
+			resp.Header.Set(name, value)
+		}
+		// HTTP dates have second precision.
+		if got := RetryAfterSeconds(resp); got < tt.want || got > tt.want+1 {
+			t.Errorf("RetryAfterSeconds(%v): want [%v], got [%v]", tt.headers, tt.want, got)
+		}
+	}
+	if got := RetryAfterSeconds(nil); got != 0 {
+		t.Errorf("RetryAfterSeconds(nil): want [0], got [%v]", got)
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		method     string
+		limited    int32
+		retryAfter string
+		timeout    time.Duration
+		statusCode int
+		wantCalls  int32
+	}{
+		{http.MethodGet, 0, "", time.Second, http.StatusOK, 1},
+		{http.MethodGet, 2, "", time.Second, http.StatusOK, 3},
+		{http.MethodHead, 1, "", time.Second, http.StatusOK, 2},
+		{http.MethodGet, 10, "", time.Second, http.StatusTooManyRequests, 4},
+		// Not idempotent so a call is not placed twice.
+		{http.MethodPost, 1, "", time.Second, http.StatusTooManyRequests, 1},
+		// The wait would pass the deadline.
+		{http.MethodGet, 1, "60", time.Second, http.StatusTooManyRequests, 1},
+	}
+	for _, tt := range tests {
+		calls := int32(0)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) <= tt.limited {
+				if len(tt.retryAfter) > 0 {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(http.StatusTooManyRequests)
+			}
+		}))
+		transport := NewRetryTransport(server.Client().Transport)
+		transport.BaseDelay = time.Millisecond
+		transport.MaxDelay = 5 * time.Millisecond
+		client := &http.Client{Transport: transport}
+
+		ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+		req, _ := http.NewRequest(tt.method, server.URL, nil)
+		resp, err := client.Do(req.WithContext(ctx))
+		cancel()
+		server.Close()
+		if err != nil {
+			t.Errorf("%v with [%v] rate limited: %v", tt.method, tt.limited, err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.statusCode || calls != tt.wantCalls {
+			t.Errorf("%v with [%v] rate limited: want status [%v] calls [%v], got [%v] [%v]",
+				tt.method, tt.limited, tt.statusCode, tt.wantCalls, resp.StatusCode, calls)
+		}
+	}
+}
+
+func TestRetryTransportDelay(t *testing.T) {
+	transport := RetryTransport{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	resp := &http.Response{Header: http.Header{}}
+	for attempt := 0; attempt < 8; attempt++ {
+		max := transport.BaseDelay << uint(attempt)
+		if max > transport.MaxDelay {
+			max = transport.MaxDelay
+		}
+		if got := transport.retryDelay(resp, attempt); got < 0 || got > max {
+			t.Errorf("retryDelay attempt [%v]: want at most [%v], got [%v]", attempt, max, got)
+		}
+	}
+	resp.Header.Set("Retry-After", "3")
+	if got := transport.retryDelay(resp, 0); got != 3*time.Second {
+		t.Errorf("retryDelay with Retry-After: want [3s], got [%v]", got)
+	}
+}
+
+func TestWriteRetryAfterAnyResponse(t *testing.T) {
+	tests := []struct {
+		format string
+		body   string
+	}{
+		{"", "ERROR RateLimited 30"},
+		{"json", "Rate limited, retry after 30 seconds"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		WriteRetryAfterAnyResponse(anyhttp.NewResponseNetHttp(rec), tt.format, 30)
+		if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "30" ||
+			!strings.Contains(rec.Body.String(), tt.body) {
+			t.Errorf("format [%v]: want [429 %v], got [%v %v %v]", tt.format, tt.body,
+				rec.Code, rec.Header().Get("Retry-After"), rec.Body.String())
+		}
+	}
+}
@@ -2,13 +2,16 @@ package handlers
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 
 	hum "github.com/grokify/gotilla/net/httputilmore"
+	log "github.com/sirupsen/logrus"
 
 	rc "github.com/grokify/go-ringcentral/client"
 	ru "github.com/grokify/go-ringcentral/clientutil"
@@ -17,7 +20,7 @@ import (
 )
 
 // RingOutRequestParams represents the full list of request
-// parameters that can be sent. All parameter names are lower-case.
+// parameters that can be sent. Parameter names are case-insensitive.
 // Supports both GET and POST.
 type RingOutRequestParams struct {
 	Cmd       string `schema:"cmd"`
@@ -32,7 +35,8 @@ type RingOutRequestParams struct {
 	Format    string `schema:"format"`
 }
 
-func NewRingOutRequestParamsFromAnyArgs(args anyhttp.Args) RingOutRequestParams {
+func NewRingOutRequestParamsFromAnyArgs(anyArgs anyhttp.Args) RingOutRequestParams {
+	args := NewLegacyArgs(anyArgs)
 	return RingOutRequestParams{
 		Cmd:       args.GetString("cmd"),
 		Username:  args.GetString("username"),
@@ -43,13 +47,13 @@ func NewRingOutRequestParamsFromAnyArgs(args anyhttp.Args) RingOutRequestParams
 		Clid:      args.GetString("clid"),
 		Prompt:    args.GetString("prompt"),
 		SessionID: args.GetString("sessionid"),
-		Format:    args.GetString("format"),
+		Format:    strings.ToLower(args.GetString("format")),
 	}
 }
 
 // HasValidCommand returns true if `cmd` is set to a supported value.
 func (params *RingOutRequestParams) HasValidCommand() bool {
-	cmds := map[string]int{"call": 1, "list": 1, "status": 0, "cancel": 0}
+	cmds := map[string]int{"call": 1, "list": 1, "status": 1, "cancel": 1}
 	if val, ok := cmds[strings.ToLower(params.Cmd)]; ok && val == 1 {
 		return true
 	}
@@ -64,24 +68,68 @@ func (params *RingOutRequestParams) PlayPrompt() bool {
 	return false
 }
 
-func RingoutListAnyResponse(aRes anyhttp.Response, apiClient *rc.APIClient, responseFormat string) {
+// RingOutID returns the `sessionid` parameter as a REST API RingOut ID.
+func (params *RingOutRequestParams) RingOutID() (int32, error) {
+	restID, err := DecodeSessionID(params.SessionID)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseInt(restID, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid SessionID [%v]", params.SessionID)
+	}
+	return int32(id), nil
+}
+
+const sessionIDPrefix = "ro"
+
+// EncodeSessionID returns a legacy base64-style Session ID
+// for a REST API RingOut ID.
+func EncodeSessionID(ringOutID string) string {
+	return base64.RawURLEncoding.EncodeToString(
+		[]byte(sessionIDPrefix + strings.TrimSpace(ringOutID)))
+}
+
+// DecodeSessionID returns the REST API RingOut ID for a legacy Session ID.
+// Numeric Session IDs are REST API RingOut IDs and are returned as is.
+func DecodeSessionID(sessionID string) (string, error) {
+	sessionID = strings.TrimSpace(sessionID)
+	if rxDigits.MatchString(sessionID) {
+		return sessionID, nil
+	}
+	bytes, err := base64.RawURLEncoding.DecodeString(sessionID)
+	if err != nil || !strings.HasPrefix(string(bytes), sessionIDPrefix) {
+		return "", fmt.Errorf("Invalid SessionID [%v]", sessionID)
+	}
+	ringOutID := strings.TrimPrefix(string(bytes), sessionIDPrefix)
+	if !rxDigits.MatchString(ringOutID) {
+		return "", fmt.Errorf("Invalid SessionID [%v]", sessionID)
+	}
+	return ringOutID, nil
+}
+
+var rxDigits = regexp.MustCompile(`^\d+$`)
+
+func RingoutListAnyResponse(ctx context.Context, aRes anyhttp.Response, apiClient *rc.APIClient, responseFormat string) {
 	info, resp, err := apiClient.CallHandlingSettingsApi.ListExtensionForwardingNumbers(
-		context.Background(), "~", "~", map[string]interface{}{})
+		ctx, "~", "~", map[string]interface{}{})
 	if err != nil {
-		anyhttp.WriteSimpleJson(aRes, http.StatusInternalServerError, err.Error())
+		if !WriteUpstreamErrorAnyResponse(ctx, aRes, resp, responseFormat, err) {
+			WriteErrorJson(aRes, http.StatusInternalServerError, err)
+		}
+	} else if responseFormat == "json" {
+		bytes, err := json.Marshal(info)
+		if err != nil {
+			WriteErrorJson(aRes, http.StatusInternalServerError, err)
+			return
+		}
+		aRes.SetContentType(hum.ContentTypeAppJsonUtf8)
+		aRes.SetStatusCode(resp.StatusCode)
+		aRes.SetBodyBytes(bytes)
 	} else {
+		aRes.SetContentType(hum.ContentTypeTextPlainUsAscii)
 		aRes.SetStatusCode(resp.StatusCode)
-		if responseFormat == "json" {
-			bytes, err := json.Marshal(info)
-			if err != nil {
-				anyhttp.WriteSimpleJson(aRes, http.StatusInternalServerError, err.Error())
-			}
-			aRes.SetContentType(hum.ContentTypeAppJsonUtf8)
-			aRes.SetBodyBytes(bytes)
-		} else {
-			aRes.SetContentType(hum.ContentTypeTextPlainUsAscii)
-			aRes.SetBodyBytes([]byte(ringoutListLegacyResponseBody(info.Records)))
-		}
+		aRes.SetBodyBytes([]byte(ringoutListLegacyResponseBody(info.Records)))
 	}
 }
 
@@ -101,24 +149,185 @@ func ringoutListLegacyResponseBody(numberInfos []rc.ForwardingNumberInfo) string
 	return fmt.Sprintf("OK %s", strings.Join(parts, ";"))
 }
 
-func RingoutCallAnyResponse(aRes anyhttp.Response, apiClient *rc.APIClient, ringOut ru.RingOutRequest, responseFormat string) {
+// RingoutCallAnyResponse places a RingOut call. If `sessions` is not nil,
+// a session cookie is set so subsequent `status` and `cancel` requests
+// do not need user credentials. `apiKeyID` is the ID of the API key
+// the request was authorized with, if any, so its sessions can be
+// deleted when it is revoked.
+func RingoutCallAnyResponse(ctx context.Context, aRes anyhttp.Response, apiClient *rc.APIClient, apiKeyID string, ringOut ru.RingOutRequest, responseFormat string, sessions *SessionStore) {
 	info, resp, err := apiClient.RingOutApi.MakeRingOutCallNew(
-		context.Background(), "~", "~", *ringOut.Body())
+		ctx, "~", "~", *ringOut.Body())
 	if err != nil {
-		aRes.SetStatusCode(http.StatusInternalServerError)
-		anyhttp.WriteSimpleJson(aRes, http.StatusInternalServerError, err.Error())
+		if !WriteUpstreamErrorAnyResponse(ctx, aRes, resp, responseFormat, err) {
+			WriteErrorJson(aRes, http.StatusInternalServerError, err)
+		}
 	} else {
-		aRes.SetStatusCode(resp.StatusCode)
+		if sessions != nil {
+			sess, err := sessions.Create(apiClient, apiKeyID, info.Id, ringOut.To, ringOut.From)
+			if err != nil {
+				log.Warnf("RingOut session not created: %v", err)
+			} else {
+				SetCookie(aRes, sess.Cookie())
+			}
+		}
 		if responseFormat == "json" {
 			bytes, err := json.Marshal(info)
 			if err != nil {
-				anyhttp.WriteSimpleJson(aRes, http.StatusInternalServerError, err.Error())
+				WriteErrorJson(aRes, http.StatusInternalServerError, err)
+				return
 			}
 			aRes.SetContentType(hum.ContentTypeAppJsonUtf8)
+			aRes.SetStatusCode(resp.StatusCode)
 			aRes.SetBodyBytes(bytes)
 		} else {
 			aRes.SetContentType(hum.ContentTypeTextPlainUsAscii)
-			aRes.SetBodyBytes([]byte(fmt.Sprintf("OK %s", info.Id)))
+			aRes.SetStatusCode(resp.StatusCode)
+			aRes.SetBodyBytes([]byte(ringoutCallLegacyResponseBody(info)))
+		}
+	}
+}
+
+// ringoutCallLegacyResponseBody returns the legacy call body in the format
+// `OK <SessionID> <WS>` where WS is the legacy general call status code.
+func ringoutCallLegacyResponseBody(info rc.GetRingOutStatusResponse) string {
+	callStatus := ""
+	if info.Status != nil {
+		callStatus = info.Status.CallStatus
+	}
+	return fmt.Sprintf("OK %s %d",
+		EncodeSessionID(info.Id),
+		int(RingOutStatusCodeFromRest(callStatus)))
+}
+
+func RingoutStatusAnyResponse(ctx context.Context, aRes anyhttp.Response, apiClient *rc.APIClient, params RingOutRequestParams) {
+	ringOutID, err := params.RingOutID()
+	if err != nil {
+		WriteLegacyErrorAnyResponse(aRes, http.StatusBadRequest, params.Format, "InvalidSessionID", err)
+		return
+	}
+	info, resp, err := apiClient.RingOutApi.GetRingOutCallStatusNew(
+		ctx, "~", "~", ringOutID)
+	if err != nil {
+		if !WriteUpstreamErrorAnyResponse(ctx, aRes, resp, params.Format, err) {
+			WriteErrorJson(aRes, http.StatusInternalServerError, err)
+		}
+	} else if params.Format == "json" {
+		bytes, err := json.Marshal(info)
+		if err != nil {
+			WriteErrorJson(aRes, http.StatusInternalServerError, err)
+			return
+		}
+		aRes.SetContentType(hum.ContentTypeAppJsonUtf8)
+		aRes.SetStatusCode(resp.StatusCode)
+		aRes.SetBodyBytes(bytes)
+	} else {
+		aRes.SetContentType(hum.ContentTypeTextPlainUsAscii)
+		aRes.SetStatusCode(resp.StatusCode)
+		aRes.SetBodyBytes([]byte(ringoutStatusLegacyResponseBody(params, info.Status)))
+	}
+}
+
+// RingoutCancelAnyResponse cancels a RingOut call. If `sessions` is not
+// nil, the sessions for the call are deleted once it is cancelled.
+func RingoutCancelAnyResponse(ctx context.Context, aRes anyhttp.Response, apiClient *rc.APIClient, params RingOutRequestParams, sessions *SessionStore) {
+	ringOutID, err := params.RingOutID()
+	if err != nil {
+		WriteLegacyErrorAnyResponse(aRes, http.StatusBadRequest, params.Format, "InvalidSessionID", err)
+		return
+	}
+	resp, err := apiClient.RingOutApi.CancelRingOutCallNew(
+		ctx, "~", "~", ringOutID)
+	if err != nil {
+		if WriteUpstreamErrorAnyResponse(ctx, aRes, resp, params.Format, err) {
+			return
+		}
+		statusCode := http.StatusInternalServerError
+		if resp != nil {
+			statusCode = resp.StatusCode
+		}
+		WriteLegacyErrorAnyResponse(aRes, statusCode, params.Format, "CancelFailed", err)
+		return
+	}
+	if sessions != nil {
+		sessions.DeleteRingOut(strconv.Itoa(int(ringOutID)))
+	}
+	if params.Format == "json" {
+		anyhttp.WriteSimpleJson(aRes, http.StatusOK, fmt.Sprintf("Cancelled RingOut [%v]", params.SessionID))
+	} else {
+		aRes.SetContentType(hum.ContentTypeTextPlainUsAscii)
+		aRes.SetStatusCode(http.StatusOK)
+		aRes.SetBodyBytes([]byte(fmt.Sprintf("OK %s", params.SessionID)))
+	}
+}
+
+// ringoutStatusLegacyResponseBody returns the legacy status body in the format
+// `OK <SessionID> <general>;<dest number>;<dest status>;<callback number>;<callback status>`.
+// The REST API does not return phone numbers so they are taken from the request.
+func ringoutStatusLegacyResponseBody(params RingOutRequestParams, status *rc.RingOutStatusInfo) string {
+	if status == nil {
+		status = &rc.RingOutStatusInfo{}
+	}
+	parts := []string{
+		strconv.Itoa(int(RingOutStatusCodeFromRest(status.CallStatus))),
+		strings.TrimSpace(params.To),
+		strconv.Itoa(int(RingOutStatusCodeFromRest(status.CalleeStatus))),
+		strings.TrimSpace(params.From),
+		strconv.Itoa(int(RingOutStatusCodeFromRest(status.CallerStatus)))}
+	return fmt.Sprintf("OK %s %s", params.SessionID, strings.Join(parts, ";"))
+}
+
+type RingOutStatusCode int
+
+const (
+	RingOutSuccess               RingOutStatusCode = iota // 0
+	RingOutInProgress                                     // 1
+	RingOutBusy                                           // 2
+	RingOutNoAnswer                                       // 3
+	RingOutRejected                                       // 4
+	RingOutGenericError                                   // 5
+	RingOutFinished                                       // 6
+	RingOutInternationalDisabled                          // 7
+	RingOutDestinationProhibited                          // 8
+)
+
+var ringOutStatusCodes = []string{
+	"Success",
+	"InProgress",
+	"Busy",
+	"NoAnswer",
+	"Rejected",
+	"GenericError",
+	"Finished",
+	"InternationalDisabled",
+	"DestinationBlocked",
+}
+
+func (code RingOutStatusCode) String() string {
+	if 0 <= int(code) && int(code) <= 8 {
+		return ringOutStatusCodes[int(code)]
+	}
+	return ""
+}
+
+// RingOutStatusCodeFromRest converts a REST API RingOut status string
+// to a legacy status code.
+/*
+0 - Success
+1 - In Progress
+2 - Busy
+3 - No Answer
+4 - Rejected
+5 - Generic Error
+6 - Finished
+7 - International calls disabled
+8 - Destination number prohibited
+*/
+func RingOutStatusCodeFromRest(status string) RingOutStatusCode {
+	status = strings.ToLower(strings.TrimSpace(status))
+	for i, name := range ringOutStatusCodes {
+		if status == strings.ToLower(name) {
+			return RingOutStatusCode(i)
 		}
 	}
+	return RingOutGenericError
 }
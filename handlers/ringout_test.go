@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	rc "github.com/grokify/go-ringcentral/client"
+	ru "github.com/grokify/go-ringcentral/clientutil"
+	"github.com/grokify/gotilla/net/anyhttp"
+)
+
+func TestRingOutStatusCodeFromRest(t *testing.T) {
+	tests := []struct {
+		status string
+		want   RingOutStatusCode
+	}{
+		{"Success", RingOutSuccess},
+		{"InProgress", RingOutInProgress},
+		{" inprogress ", RingOutInProgress},
+		{"Busy", RingOutBusy},
+		{"NoAnswer", RingOutNoAnswer},
+		{"Rejected", RingOutRejected},
+		{"GenericError", RingOutGenericError},
+		{"Finished", RingOutFinished},
+		{"InternationalDisabled", RingOutInternationalDisabled},
+		{"DestinationBlocked", RingOutDestinationProhibited},
+		{"", RingOutGenericError},
+		{"Unknown", RingOutGenericError},
+	}
+	for _, tt := range tests {
+		if got := RingOutStatusCodeFromRest(tt.status); got != tt.want {
+			t.Errorf("RingOutStatusCodeFromRest(%q): want [%v], got [%v]", tt.status, tt.want, got)
+		}
+	}
+}
+
+func TestRingOutStatusCodeString(t *testing.T) {
+	tests := []struct {
+		code RingOutStatusCode
+		want string
+	}{
+		{RingOutSuccess, "Success"},
+		{RingOutFinished, "Finished"},
+		{RingOutDestinationProhibited, "DestinationBlocked"},
+		{RingOutStatusCode(-1), ""},
+		{RingOutStatusCode(9), ""},
+	}
+	for _, tt := range tests {
+		if got := tt.code.String(); got != tt.want {
+			t.Errorf("RingOutStatusCode(%d).String(): want [%v], got [%v]", int(tt.code), tt.want, got)
+		}
+	}
+}
+
+func TestRingoutStatusLegacyResponseBody(t *testing.T) {
+	params := RingOutRequestParams{SessionID: "cm80NTY3", To: "16505550101", From: " 16505550102 "}
+	tests := []struct {
+		status *rc.RingOutStatusInfo
+		want   string
+	}{
+		{&rc.RingOutStatusInfo{CallStatus: "InProgress", CalleeStatus: "Busy", CallerStatus: "Success"},
+			"OK cm80NTY3 1;16505550101;2;16505550102;0"},
+		{&rc.RingOutStatusInfo{CallStatus: "Success", CalleeStatus: "NoAnswer", CallerStatus: "Finished"},
+			"OK cm80NTY3 0;16505550101;3;16505550102;6"},
+		{nil, "OK cm80NTY3 5;16505550101;5;16505550102;5"},
+	}
+	for _, tt := range tests {
+		if got := ringoutStatusLegacyResponseBody(params, tt.status); got != tt.want {
+			t.Errorf("ringoutStatusLegacyResponseBody(%+v): want [%v], got [%v]", tt.status, tt.want, got)
+		}
+	}
+}
+
+func TestSessionIDRoundTrip(t *testing.T) {
+	for _, ringOutID := range []string{"0", "4567", "2147483647", "12345678901234"} {
+		sessionID := EncodeSessionID(ringOutID)
+		if sessionID == ringOutID {
+			t.Errorf("EncodeSessionID(%q): not encoded", ringOutID)
+		}
+		got, err := DecodeSessionID(sessionID)
+		if err != nil || got != ringOutID {
+			t.Errorf("DecodeSessionID(EncodeSessionID(%q)): want [%v], got [%v] %v", ringOutID, ringOutID, got, err)
+		}
+	}
+}
+
+func TestDecodeSessionID(t *testing.T) {
+	tests := []struct {
+		sessionID string
+		want      string
+		wantErr   bool
+	}{
+		{"cm80NTY3", "4567", false},
+		{" cm80NTY3 ", "4567", false},
+		{"4567", "4567", false},
+		{"", "", true},
+		{"not base64!", "", true},
+		{EncodeSessionID("abc"), "", true},
+		// Base64 of "xx4567" without the `ro` prefix.
+		{"eHg0NTY3", "", true},
+	}
+	for _, tt := range tests {
+		got, err := DecodeSessionID(tt.sessionID)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("DecodeSessionID(%q): want [%v] error [%v], got [%v] %v", tt.sessionID, tt.want, tt.wantErr, got, err)
+		}
+	}
+}
+
+func TestRingOutRequestParamsRingOutID(t *testing.T) {
+	tests := []struct {
+		sessionID string
+		want      int32
+		wantErr   bool
+	}{
+		{EncodeSessionID("4567"), 4567, false},
+		{"4567", 4567, false},
+		{EncodeSessionID("2147483648"), 0, true},
+		{"invalid", 0, true},
+	}
+	for _, tt := range tests {
+		params := RingOutRequestParams{SessionID: tt.sessionID}
+		got, err := params.RingOutID()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("RingOutID(%q): want [%v] error [%v], got [%v] %v", tt.sessionID, tt.want, tt.wantErr, got, err)
+		}
+	}
+}
+
+func TestRingoutCallLegacyResponseBody(t *testing.T) {
+	tests := []struct {
+		info rc.GetRingOutStatusResponse
+		want string
+	}{
+		{rc.GetRingOutStatusResponse{Id: "4567", Status: &rc.RingOutStatusInfo{CallStatus: "InProgress"}}, "OK cm80NTY3 1"},
+		{rc.GetRingOutStatusResponse{Id: "4567"}, "OK cm80NTY3 5"},
+	}
+	for _, tt := range tests {
+		if got := ringoutCallLegacyResponseBody(tt.info); got != tt.want {
+			t.Errorf("ringoutCallLegacyResponseBody(%+v): want [%v], got [%v]", tt.info, tt.want, got)
+		}
+	}
+}
+
+// newRingOutAPIServer returns a REST API stand-in which cancels the
+// RingOut call `4567` and returns a 404 for other calls.
+func newRingOutAPIServer(t *testing.T) (*httptest.Server, *rc.APIClient) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete && r.URL.Path == "/restapi/v1.0/account/~/extension/~/ring-out/4567" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errorCode":"CMN-102","message":"Resource for parameter [ringoutId] is not found"}`))
+	}))
+	t.Cleanup(server.Close)
+	apiClient, err := ru.NewApiClientHttpClientBaseURL(server.Client(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, apiClient
+}
+
+func TestRingoutCancelAnyResponse(t *testing.T) {
+	_, apiClient := newRingOutAPIServer(t)
+	tests := []struct {
+		sessionID  string
+		format     string
+		statusCode int
+		body       string
+	}{
+		{"4567", "", http.StatusOK, "OK 4567"},
+		{"4567", "json", http.StatusOK, "Cancelled RingOut [4567]"},
+		{"4568", "", http.StatusNotFound, "ERROR CancelFailed"},
+		{"4568", "json", http.StatusNotFound, "404"},
+		{"abc", "", http.StatusBadRequest, "ERROR InvalidSessionID"},
+		{"abc", "json", http.StatusBadRequest, "Invalid SessionID [abc]"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		RingoutCancelAnyResponse(context.Background(), anyhttp.NewResponseNetHttp(rec), apiClient,
+			RingOutRequestParams{SessionID: tt.sessionID, Format: tt.format}, nil)
+		if rec.Code != tt.statusCode || !strings.Contains(rec.Body.String(), tt.body) {
+			t.Errorf("RingoutCancelAnyResponse(%q, %q): want [%v %v], got [%v %v]",
+				tt.sessionID, tt.format, tt.statusCode, tt.body, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestRingoutCancelDeletesSession(t *testing.T) {
+	_, apiClient := newRingOutAPIServer(t)
+	sessions := NewSessionStore(0)
+	tests := []struct {
+		ringOutID string
+		kept      bool
+	}{
+		{"4567", false},
+		{"4568", true},
+	}
+	for _, tt := range tests {
+		sess, err := sessions.Create(apiClient, "", tt.ringOutID, "+16505550100", "+16505550101")
+		if err != nil {
+			t.Fatal(err)
+		}
+		RingoutCancelAnyResponse(context.Background(), anyhttp.NewResponseNetHttp(httptest.NewRecorder()), apiClient,
+			RingOutRequestParams{SessionID: EncodeSessionID(tt.ringOutID)}, sessions)
+		if _, ok := sessions.Get(sess.ID); ok != tt.kept {
+			t.Errorf("RingOut [%v] cancelled: want session kept [%v], got [%v]", tt.ringOutID, tt.kept, ok)
+		}
+	}
+}
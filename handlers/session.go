@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	rc "github.com/grokify/go-ringcentral/client"
+)
+
+const (
+	SessionCookieName = "RCLegacySession"
+	DefaultSessionTTL = 30 * time.Minute
+)
+
+// RingOutSession holds the authorized API client for a RingOut `call`
+// so legacy clients can send `status` and `cancel` with only the
+// session cookie and `sessionid`. `APIKeyID` is the ID of the API key
+// the call was authorized with, if any.
+type RingOutSession struct {
+	ID        string
+	APIClient *rc.APIClient
+	APIKeyID  string
+	RingOutID string
+	To        string
+	From      string
+	Expires   time.Time
+}
+
+// SessionStore is a concurrency-safe in-memory RingOut session store
+// keyed by an opaque cookie value.
+type SessionStore struct {
+	TTL      time.Duration
+	mutex    sync.Mutex
+	sessions map[string]*RingOutSession
+}
+
+func NewSessionStore(ttl time.Duration) *SessionStore {
+	if ttl <= 0 {
+		ttl = DefaultSessionTTL
+	}
+	return &SessionStore{
+		TTL:      ttl,
+		sessions: map[string]*RingOutSession{}}
+}
+
+// Create adds a new session with a random ID.
+func (store *SessionStore) Create(apiClient *rc.APIClient, apiKeyID, ringOutID, to, from string) (*RingOutSession, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+	sess := &RingOutSession{
+		ID:        id,
+		APIClient: apiClient,
+		APIKeyID:  apiKeyID,
+		RingOutID: ringOutID,
+		To:        to,
+		From:      from,
+		Expires:   time.Now().Add(store.TTL)}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purgeExpired()
+	store.sessions[id] = sess
+	return sess, nil
+}
+
+// Get returns an unexpired session.
+func (store *SessionStore) Get(id string) (*RingOutSession, bool) {
+	if len(id) == 0 {
+		return nil, false
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	sess, ok := store.sessions[id]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(sess.Expires) {
+		delete(store.sessions, id)
+		return nil, false
+	}
+	return sess, true
+}
+
+func (store *SessionStore) Delete(id string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	delete(store.sessions, id)
+}
+
+// DeleteRingOut deletes the sessions for the RingOut call ID.
+func (store *SessionStore) DeleteRingOut(ringOutID string) {
+	if len(ringOutID) == 0 {
+		return
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	for id, sess := range store.sessions {
+		if sess.RingOutID == ringOutID {
+			delete(store.sessions, id)
+		}
+	}
+}
+
+// DeleteAPIKey deletes the sessions created with the API key ID and
+// returns the number deleted.
+func (store *SessionStore) DeleteAPIKey(apiKeyID string) int {
+	if len(apiKeyID) == 0 {
+		return 0
+	}
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	deleted := 0
+	for id, sess := range store.sessions {
+		if sess.APIKeyID == apiKeyID {
+			delete(store.sessions, id)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// SessionInfo is a session as returned by the admin API. The session
+// ID is a credential so the `ID` is a hash of it, and phone numbers
+// are masked.
+type SessionInfo struct {
+	ID        string    `json:"id"`
+	APIKeyID  string    `json:"apiKeyId,omitempty"`
+	RingOutID string    `json:"ringOutId"`
+	To        string    `json:"to"`
+	From      string    `json:"from"`
+	Expires   time.Time `json:"expires"`
+}
+
+// PublicID returns the ID used for the session in the admin API.
+func (sess *RingOutSession) PublicID() string {
+	sum := sha256.Sum256([]byte(sess.ID))
+	return hex.EncodeToString(sum[:8])
+}
+
+// List returns the unexpired sessions, soonest expiry first.
+func (store *SessionStore) List() []SessionInfo {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.purgeExpired()
+	infos := []SessionInfo{}
+	for _, sess := range store.sessions {
+		infos = append(infos, SessionInfo{
+			ID:        sess.PublicID(),
+			APIKeyID:  sess.APIKeyID,
+			RingOutID: sess.RingOutID,
+			To:        MaskPhoneNumber(sess.To),
+			From:      MaskPhoneNumber(sess.From),
+			Expires:   sess.Expires})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Expires.Before(infos[j].Expires) })
+	return infos
+}
+
+// Cancel cancels the RingOut call for the session with the public ID
+// and deletes the session. It returns false if there is no session.
+// Sessions whose call the REST API no longer has are also deleted.
+func (store *SessionStore) Cancel(ctx context.Context, publicID string) (bool, error) {
+	store.mutex.Lock()
+	var sess *RingOutSession
+	for _, try := range store.sessions {
+		if try.PublicID() == publicID && time.Now().Before(try.Expires) {
+			sess = try
+			break
+		}
+	}
+	store.mutex.Unlock()
+	if sess == nil {
+		return false, nil
+	}
+	ringOutID, err := strconv.ParseInt(sess.RingOutID, 10, 32)
+	if err != nil {
+		return true, fmt.Errorf("Invalid RingOut ID [%v]", sess.RingOutID)
+	}
+	resp, err := sess.APIClient.RingOutApi.CancelRingOutCallNew(ctx, "~", "~", int32(ringOutID))
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return true, fmt.Errorf("RingOut [%v] not cancelled: %v", sess.RingOutID, RedactCredentials(err.Error()))
+	}
+	store.Delete(sess.ID)
+	return true, nil
+}
+
+func (store *SessionStore) purgeExpired() {
+	now := time.Now()
+	for id, sess := range store.sessions {
+		if now.After(sess.Expires) {
+			delete(store.sessions, id)
+		}
+	}
+}
+
+// Cookie returns the `Set-Cookie` value for the session.
+func (sess *RingOutSession) Cookie() *http.Cookie {
+	return &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    sess.ID,
+		Path:     "/",
+		Expires:  sess.Expires,
+		HttpOnly: true}
+}
+
+func newSessionID() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
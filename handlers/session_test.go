@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionStoreGetExpiry(t *testing.T) {
+	store := NewSessionStore(50 * time.Millisecond)
+	sess, err := store.Create(nil, "", "4567", "+16505550100", "+16505550101")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := store.Create(nil, "", "4568", "+16505550100", "+16505550101")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess.ID == other.ID || len(sess.ID) < 32 {
+		t.Errorf("session IDs not unique random values: [%v] [%v]", sess.ID, other.ID)
+	}
+	cookie := sess.Cookie()
+	if cookie.Name != SessionCookieName || cookie.Value != sess.ID || !cookie.HttpOnly || !cookie.Expires.Equal(sess.Expires) {
+		t.Errorf("RingOutSession.Cookie: got %+v", cookie)
+	}
+
+	tests := []struct {
+		id   string
+		wait time.Duration
+		want bool
+	}{
+		{sess.ID, 0, true},
+		{"", 0, false},
+		{"unknown", 0, false},
+		{sess.ID, 60 * time.Millisecond, false},
+	}
+	for _, tt := range tests {
+		time.Sleep(tt.wait)
+		got, ok := store.Get(tt.id)
+		if ok != tt.want || (ok && got.RingOutID != "4567") {
+			t.Errorf("SessionStore.Get(%q) after [%v]: want [%v], got [%v]", tt.id, tt.wait, tt.want, ok)
+		}
+	}
+	store.Delete(other.ID)
+	if _, ok := store.Get(other.ID); ok {
+		t.Error("deleted session found")
+	}
+}
+
+func TestSessionStoreDeleteAPIKey(t *testing.T) {
+	store := NewSessionStore(time.Minute)
+	for _, apiKeyID := range []string{"", "", "key1", "key1", "key2"} {
+		if _, err := store.Create(nil, apiKeyID, "4567", "+16505550100", "+16505550101"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		apiKeyID    string
+		wantDeleted int
+		wantLeft    int
+	}{
+		{"", 0, 5},
+		{"unknown", 0, 5},
+		{"key1", 2, 3},
+		{"key1", 0, 3},
+		{"key2", 1, 2},
+	}
+	for _, tt := range tests {
+		if got := store.DeleteAPIKey(tt.apiKeyID); got != tt.wantDeleted {
+			t.Errorf("SessionStore.DeleteAPIKey(%q): want [%v], got [%v]", tt.apiKeyID, tt.wantDeleted, got)
+		}
+		if got := len(store.List()); got != tt.wantLeft {
+			t.Errorf("SessionStore.DeleteAPIKey(%q): want [%v] sessions left, got [%v]", tt.apiKeyID, tt.wantLeft, got)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"fmt"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPMailer sends plain-text email through an SMTP relay. Auth is
+// only used if `Username` is set so a local relay can be used.
+type SMTPMailer struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (mailer *SMTPMailer) Send(to []string, subject, body string) error {
+	if len(to) == 0 {
+		return fmt.Errorf("No email recipients")
+	}
+	var auth smtp.Auth
+	if len(mailer.Username) > 0 {
+		host := mailer.Addr
+		if idx := strings.LastIndex(host, ":"); idx > -1 {
+			host = host[:idx]
+		}
+		auth = smtp.PlainAuth("", mailer.Username, mailer.Password, host)
+	}
+	return smtp.SendMail(mailer.Addr, auth, mailer.From, to,
+		buildPlainTextEmail(mailer.From, to, subject, body))
+}
+
+func buildPlainTextEmail(from string, to []string, subject, body string) []byte {
+	lines := []string{
+		"From: " + from,
+		"To: " + strings.Join(to, ", "),
+		"Subject: " + subject,
+		"Date: " + time.Now().UTC().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		strings.Replace(body, "\n", "\r\n", -1)}
+	return []byte(strings.Join(lines, "\r\n"))
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildPlainTextEmail(t *testing.T) {
+	email := string(buildPlainTextEmail("fax@example.com", []string{"a@example.com", "b@example.com"},
+		"Fax Sent", "Fax 4567 Sent\nTo: +16505550100"))
+	tests := []string{
+		"From: fax@example.com\r\n",
+		"To: a@example.com, b@example.com\r\n",
+		"Subject: Fax Sent\r\n",
+		"Content-Type: text/plain; charset=utf-8\r\n",
+		"\r\n\r\nFax 4567 Sent\r\nTo: +16505550100",
+	}
+	for _, want := range tests {
+		if !strings.Contains(email, want) {
+			t.Errorf("buildPlainTextEmail: want [%q] in [%q]", want, email)
+		}
+	}
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	addr, messages := startSMTPStandIn(t)
+	tests := []struct {
+		mailer  SMTPMailer
+		to      []string
+		wantErr bool
+	}{
+		{SMTPMailer{Addr: addr, From: "fax@example.com"}, []string{"a@example.com", "b@example.com"}, false},
+		{SMTPMailer{Addr: addr, From: "fax@example.com"}, nil, true},
+		// The stand-in does not support AUTH.
+		{SMTPMailer{Addr: addr, From: "fax@example.com", Username: "user", Password: "password"}, []string{"a@example.com"}, true},
+	}
+	for _, tt := range tests {
+		err := tt.mailer.Send(tt.to, "Fax Sent", "Fax 4567 Sent")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("SMTPMailer.Send(%v) username [%v]: want error [%v], got [%v]", tt.to, tt.mailer.Username, tt.wantErr, err)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		select {
+		case message := <-messages:
+			if !strings.Contains(message, "Subject: Fax Sent") || !strings.Contains(message, "Fax 4567 Sent") {
+				t.Errorf("SMTPMailer.Send: got message [%v]", message)
+			}
+		case <-time.After(time.Second):
+			t.Error("SMTPMailer.Send: no message received")
+		}
+	}
+}
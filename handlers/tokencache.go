@@ -0,0 +1,447 @@
+package handlers
+
+import (
+	"container/list"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	ru "github.com/grokify/go-ringcentral/clientutil"
+	hum "github.com/grokify/gotilla/net/httputilmore"
+	om "github.com/grokify/oauth2more"
+	ro "github.com/grokify/oauth2more/ringcentral"
+	"golang.org/x/oauth2"
+
+	rc "github.com/grokify/go-ringcentral/client"
+)
+
+const (
+	DefaultTokenCacheSize     = 1000
+	DefaultTokenRefreshBefore = 5 * time.Minute
+)
+
+// TokenCache is a concurrency-safe LRU cache of authorized API clients
+// keyed by a salted hash of the server URL, client ID and user
+// credentials. Tokens are refreshed with the refresh token before they
+// expire and entries are evicted when the API returns a 401. Token
+// requests for the same key are serialized so a rotating refresh token
+// is only redeemed once.
+type TokenCache struct {
+	MaxEntries    int
+	RefreshBefore time.Duration
+	mutex         sync.Mutex
+	salt          []byte
+	lru           *list.List
+	entries       map[string]*list.Element
+	grants        map[string]*grantLock
+	Metrics       *Metrics
+}
+
+// grantLock serializes token requests for a key. `waiters` counts the
+// requests holding or waiting for it so it can be removed when unused.
+type grantLock struct {
+	sync.Mutex
+	waiters int
+}
+
+type tokenCacheEntry struct {
+	key       string
+	user      string
+	app       ro.ApplicationCredentials
+	apiClient *rc.APIClient
+	source    *refreshTokenSource
+}
+
+// TokenInfo is a cached token as returned by the admin API. The `ID`
+// is a prefix of the cache key and the `User` is masked.
+type TokenInfo struct {
+	ID          string    `json:"id"`
+	User        string    `json:"user"`
+	ServerURL   string    `json:"serverUrl"`
+	ClientID    string    `json:"clientId"`
+	Expiry      time.Time `json:"expiry"`
+	Refreshable bool      `json:"refreshable"`
+}
+
+const tokenInfoIDLength = 16
+
+func NewTokenCache(maxEntries int) (*TokenCache, error) {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if maxEntries <= 0 {
+		maxEntries = DefaultTokenCacheSize
+	}
+	return &TokenCache{
+		MaxEntries:    maxEntries,
+		RefreshBefore: DefaultTokenRefreshBefore,
+		salt:          salt,
+		lru:           list.New(),
+		entries:       map[string]*list.Element{},
+		grants:        map[string]*grantLock{}}, nil
+}
+
+// Key returns the salted hash for the server URL, client ID and user
+// credentials.
+func (cache *TokenCache) Key(app ro.ApplicationCredentials, pwd ro.PasswordCredentials) string {
+	return cache.key(app, ro.UsernameExtensionPasswordToString(pwd.Username, pwd.Extension, pwd.Password))
+}
+
+func (cache *TokenCache) key(app ro.ApplicationCredentials, user string) string {
+	mac := hmac.New(sha256.New, cache.salt)
+	mac.Write([]byte(strings.Join([]string{
+		strings.TrimSpace(app.ServerURL),
+		strings.TrimSpace(app.ClientID),
+		user}, "\t")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// APIClient returns a cached API client for the credentials, performing
+// a password grant if none is cached or the cached token cannot be used.
+// The token request is bound to `ctx`.
+func (cache *TokenCache) APIClient(ctx context.Context, app ro.ApplicationCredentials, pwd ro.PasswordCredentials) (*rc.APIClient, error) {
+	user := pwd.Username
+	if len(pwd.Extension) > 0 {
+		user += "*" + pwd.Extension
+	}
+	return cache.apiClient(ctx, app, cache.Key(app, pwd), user, pwd.URLValues(), nil)
+}
+
+// GrantAPIClient returns a cached API client for `user`, an ID for
+// credentials such as an API key, performing the token request with
+// `params` if none is cached. `onToken`, if set, is called with each
+// new token, e.g. to save a rotated refresh token.
+func (cache *TokenCache) GrantAPIClient(ctx context.Context, app ro.ApplicationCredentials, user string, params url.Values, onToken func(*oauth2.Token)) (*rc.APIClient, error) {
+	return cache.apiClient(ctx, app, cache.key(app, user), user, params, onToken)
+}
+
+func (cache *TokenCache) apiClient(ctx context.Context, app ro.ApplicationCredentials, key, user string, params url.Values, onToken func(*oauth2.Token)) (*rc.APIClient, error) {
+	if apiClient, ok := cache.get(key); ok {
+		cache.Metrics.ObserveTokenCache(true)
+		return apiClient, nil
+	}
+	unlock := cache.lockGrant(key)
+	defer unlock()
+	// Another request may have added the entry while this one waited.
+	if apiClient, ok := cache.get(key); ok {
+		cache.Metrics.ObserveTokenCache(true)
+		return apiClient, nil
+	}
+	cache.Metrics.ObserveTokenCache(false)
+
+	conf := app.Config()
+	token, err := RetrieveToken(ctx, conf, params)
+	if err != nil {
+		return nil, err
+	}
+	if onToken != nil {
+		onToken(token)
+	}
+	source := &refreshTokenSource{
+		conf:          conf,
+		token:         token,
+		refreshBefore: cache.RefreshBefore,
+		onInvalid:     func() { cache.Evict(key) },
+		onToken:       onToken}
+	httpClient := &http.Client{
+		Transport: upstreamLogTransport{
+			metrics: cache.Metrics,
+			Transport: evictingTransport{
+				Transport: NewRetryTransport(tokenTransport{source: source}),
+				onUnauthorized: func() {
+					cache.Evict(key)
+				}}}}
+	apiClient, err := ru.NewApiClientHttpClientBaseURL(httpClient, app.ServerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.add(&tokenCacheEntry{
+		key:       key,
+		user:      user,
+		app:       app,
+		apiClient: apiClient,
+		source:    source})
+	return apiClient, nil
+}
+
+// lockGrant locks token requests for the key and returns a function
+// which unlocks them.
+func (cache *TokenCache) lockGrant(key string) func() {
+	cache.mutex.Lock()
+	lock, ok := cache.grants[key]
+	if !ok {
+		lock = &grantLock{}
+		cache.grants[key] = lock
+	}
+	lock.waiters++
+	cache.mutex.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		cache.mutex.Lock()
+		defer cache.mutex.Unlock()
+		if lock.waiters--; lock.waiters == 0 {
+			delete(cache.grants, key)
+		}
+	}
+}
+
+func (cache *TokenCache) get(key string) (*rc.APIClient, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	elem, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*tokenCacheEntry)
+	if !entry.source.usable() {
+		cache.removeElement(elem)
+		return nil, false
+	}
+	cache.lru.MoveToFront(elem)
+	return entry.apiClient, true
+}
+
+func (cache *TokenCache) add(entry *tokenCacheEntry) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if elem, ok := cache.entries[entry.key]; ok {
+		cache.removeElement(elem)
+	}
+	cache.entries[entry.key] = cache.lru.PushFront(entry)
+	for cache.lru.Len() > cache.MaxEntries {
+		cache.removeElement(cache.lru.Back())
+	}
+}
+
+// Evict removes the entry for a key.
+func (cache *TokenCache) Evict(key string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if elem, ok := cache.entries[key]; ok {
+		cache.removeElement(elem)
+	}
+}
+
+// EvictID removes the entry whose `TokenInfo` ID is `id`.
+func (cache *TokenCache) EvictID(id string) bool {
+	if len(id) != tokenInfoIDLength {
+		return false
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	for key, elem := range cache.entries {
+		if strings.HasPrefix(key, id) {
+			cache.removeElement(elem)
+			return true
+		}
+	}
+	return false
+}
+
+// EvictUser removes the entries for a user, e.g. `apikey:<id>`, and
+// returns the number removed.
+func (cache *TokenCache) EvictUser(user string) int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	evicted := 0
+	for _, elem := range cache.entries {
+		if elem.Value.(*tokenCacheEntry).user == user {
+			cache.removeElement(elem)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+// Tokens returns the cached tokens, most recently used first, with
+// usernames masked. If `user` is set, only tokens for the user are
+// returned. `user` is an API key user such as `apikey:<id>`, or a
+// username which matches all of its extensions unless it has one, as in
+// `<username>*<extension>`.
+func (cache *TokenCache) Tokens(user string) []TokenInfo {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	tokens := []TokenInfo{}
+	for elem := cache.lru.Front(); elem != nil; elem = elem.Next() {
+		entry := elem.Value.(*tokenCacheEntry)
+		if len(user) > 0 && !tokenUserMatches(entry.user, user) {
+			continue
+		}
+		user := entry.user
+		if !strings.HasPrefix(user, "apikey:") {
+			user = MaskPhoneNumber(user)
+		}
+		expiry, refreshable := entry.source.info()
+		tokens = append(tokens, TokenInfo{
+			ID:          entry.key[:tokenInfoIDLength],
+			User:        user,
+			ServerURL:   entry.app.ServerURL,
+			ClientID:    entry.app.ClientID,
+			Expiry:      expiry,
+			Refreshable: refreshable})
+	}
+	return tokens
+}
+
+// tokenUserMatches returns true if the cache entry user matches the
+// `user` filter, comparing usernames as phone numbers.
+func tokenUserMatches(entryUser, user string) bool {
+	user = strings.TrimSpace(user)
+	if strings.HasPrefix(entryUser, "apikey:") || strings.HasPrefix(user, "apikey:") {
+		return entryUser == user
+	}
+	entryUsername, entryExtension := ParseLegacyUsername(entryUser)
+	username, extension := ParseLegacyUsername(user)
+	if entryUsername != username {
+		return false
+	}
+	return len(extension) == 0 || extension == entryExtension
+}
+
+// Len returns the number of cached entries.
+func (cache *TokenCache) Len() int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.lru.Len()
+}
+
+func (cache *TokenCache) removeElement(elem *list.Element) {
+	cache.lru.Remove(elem)
+	delete(cache.entries, elem.Value.(*tokenCacheEntry).key)
+}
+
+// refreshTokenSource refreshes the token `refreshBefore` ahead of expiry
+// instead of after it has expired.
+type refreshTokenSource struct {
+	mutex         sync.Mutex
+	conf          oauth2.Config
+	token         *oauth2.Token
+	refreshBefore time.Duration
+	onInvalid     func()
+	onToken       func(*oauth2.Token)
+}
+
+// Token returns the token, refreshing it with a request bound to `ctx`
+// if needed.
+func (src *refreshTokenSource) Token(ctx context.Context) (*oauth2.Token, error) {
+	token, err := src.refresh(ctx)
+	if err != nil && ctx.Err() == nil {
+		src.onInvalid()
+	}
+	return token, err
+}
+
+func (src *refreshTokenSource) refresh(ctx context.Context) (*oauth2.Token, error) {
+	src.mutex.Lock()
+	defer src.mutex.Unlock()
+	if time.Now().Add(src.refreshBefore).Before(src.token.Expiry) {
+		return src.token, nil
+	}
+	if len(src.token.RefreshToken) == 0 {
+		return nil, fmt.Errorf("Access token expired with no refresh token")
+	}
+	token, err := RetrieveToken(ctx, src.conf, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {src.token.RefreshToken}})
+	if err != nil {
+		return nil, err
+	}
+	src.token = token
+	if src.onToken != nil {
+		src.onToken(token)
+	}
+	return token, nil
+}
+
+// info returns the access token expiry and whether it can be refreshed.
+func (src *refreshTokenSource) info() (time.Time, bool) {
+	src.mutex.Lock()
+	defer src.mutex.Unlock()
+	return src.token.Expiry, len(src.token.RefreshToken) > 0
+}
+
+// usable returns true if the token is unexpired or can be refreshed.
+func (src *refreshTokenSource) usable() bool {
+	src.mutex.Lock()
+	defer src.mutex.Unlock()
+	return len(src.token.RefreshToken) > 0 || time.Now().Before(src.token.Expiry)
+}
+
+// evictingTransport calls `onUnauthorized` when the API returns a 401.
+type evictingTransport struct {
+	Transport      http.RoundTripper
+	onUnauthorized func()
+}
+
+func (t evictingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Transport.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		t.onUnauthorized()
+	}
+	return resp, err
+}
+
+// tokenTransport authorizes requests with the source token, refreshing
+// it with the request context so refreshes share the request deadline.
+type tokenTransport struct {
+	source *refreshTokenSource
+}
+
+func (t tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.source.Token(req.Context())
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	authReq := req.Clone(req.Context())
+	token.SetAuthHeader(authReq)
+	return http.DefaultTransport.RoundTrip(authReq)
+}
+
+// RetrieveToken requests a token from the app token endpoint with
+// `params`. Unlike `ringcentral.RetrieveToken`, the request is bound to
+// `ctx` so it honors the caller's deadline.
+func RetrieveToken(ctx context.Context, conf oauth2.Config, params url.Values) (*oauth2.Token, error) {
+	body := params.Encode()
+	req, err := http.NewRequest(http.MethodPost, conf.Endpoint.TokenURL, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	basicAuthHeader, err := om.BasicAuthHeader(conf.ClientID, conf.ClientSecret)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(hum.HeaderAuthorization, basicAuthHeader)
+	req.Header.Set(hum.HeaderContentType, hum.ContentTypeAppFormUrlEncoded)
+	req.Header.Set(hum.HeaderContentLength, strconv.Itoa(len(body)))
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("RingCentral API Response Status %v", resp.StatusCode)
+	}
+	rcToken := &ro.RcToken{}
+	if err := json.NewDecoder(resp.Body).Decode(rcToken); err != nil {
+		return nil, err
+	}
+	return rcToken.OAuth2Token()
+}
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ro "github.com/grokify/oauth2more/ringcentral"
+	"golang.org/x/oauth2"
+)
+
+// newRestAPIServer returns a REST API stand-in whose token endpoint
+// issues access tokens `at1`, `at2`, ... and whose API endpoints return
+// a 401 for access tokens in `revoked`.
+func newRestAPIServer(t *testing.T, expiresIn int, revoked map[string]bool) (*httptest.Server, *int32) {
+	grants := new(int32)
+	mutex := sync.Mutex{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/restapi/oauth/token" {
+			n := atomic.AddInt32(grants, 1)
+			fmt.Fprintf(w, `{"access_token":"at%d","token_type":"bearer","expires_in":%d,"refresh_token":"rt%d"}`, n, expiresIn, n)
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		if revoked[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")] {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		fmt.Fprint(w, `{}`)
+	}))
+	t.Cleanup(server.Close)
+	return server, grants
+}
+
+func TestTokenCacheEvictsOnUnauthorized(t *testing.T) {
+	server, grants := newRestAPIServer(t, 3600, map[string]bool{"at1": true})
+	cache, err := NewTokenCache(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := ro.ApplicationCredentials{ServerURL: server.URL, ClientID: "id", ClientSecret: "secret"}
+	pwd := ro.PasswordCredentials{Username: "+16505550100", Password: "password"}
+
+	tests := []struct {
+		statusCode int
+		wantGrants int32
+		wantLen    int
+	}{
+		// `at1` is rejected so the entry is evicted.
+		{http.StatusUnauthorized, 1, 0},
+		{http.StatusOK, 2, 1},
+		{http.StatusOK, 2, 1},
+	}
+	for i, tt := range tests {
+		apiClient, err := cache.APIClient(context.Background(), app, pwd)
+		if err != nil {
+			t.Fatalf("request %d: TokenCache.APIClient: %v", i, err)
+		}
+		resp, err := apiClient.HTTPClient().Get(server.URL + "/restapi/v1.0/account/~/extension/~")
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.statusCode || atomic.LoadInt32(grants) != tt.wantGrants || cache.Len() != tt.wantLen {
+			t.Errorf("request %d: want status [%v] grants [%v] entries [%v], got [%v] [%v] [%v]", i,
+				tt.statusCode, tt.wantGrants, tt.wantLen, resp.StatusCode, atomic.LoadInt32(grants), cache.Len())
+		}
+	}
+}
+
+func TestTokenCacheRefreshesBeforeExpiry(t *testing.T) {
+	// Tokens expire within `RefreshBefore` so each request refreshes.
+	server, grants := newRestAPIServer(t, 60, map[string]bool{})
+	cache, err := NewTokenCache(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := ro.ApplicationCredentials{ServerURL: server.URL, ClientID: "id", ClientSecret: "secret"}
+	pwd := ro.PasswordCredentials{Username: "+16505550100", Password: "password"}
+	for i := 1; i <= 3; i++ {
+		apiClient, err := cache.APIClient(context.Background(), app, pwd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := apiClient.HTTPClient().Get(server.URL + "/restapi/v1.0/account/~/extension/~")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := atomic.LoadInt32(grants); got != int32(i+1) {
+			t.Errorf("request %d: want [%v] token requests, got [%v]", i, i+1, got)
+		}
+	}
+	if cache.Len() != 1 {
+		t.Errorf("want [1] entry, got [%v]", cache.Len())
+	}
+}
+
+func TestTokenCacheLRUEviction(t *testing.T) {
+	server, grants := newRestAPIServer(t, 3600, map[string]bool{})
+	cache, err := NewTokenCache(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := ro.ApplicationCredentials{ServerURL: server.URL, ClientID: "id", ClientSecret: "secret"}
+	tests := []struct {
+		user       string
+		wantGrants int32
+	}{
+		{"+16505550100", 1},
+		{"+16505550101", 2},
+		{"+16505550100", 2},
+		// Evicts the least recently used `+16505550101`.
+		{"+16505550102", 3},
+		{"+16505550100", 3},
+		{"+16505550101", 4},
+	}
+	for _, tt := range tests {
+		pwd := ro.PasswordCredentials{Username: tt.user, Password: "password"}
+		if _, err := cache.APIClient(context.Background(), app, pwd); err != nil {
+			t.Fatal(err)
+		}
+		if got := atomic.LoadInt32(grants); got != tt.wantGrants || cache.Len() > 2 {
+			t.Errorf("user [%v]: want [%v] token requests, got [%v] with [%v] entries", tt.user, tt.wantGrants, got, cache.Len())
+		}
+	}
+}
+
+// newTokenServer returns a token endpoint stand-in which waits `delay`
+// before responding to each request.
+func newTokenServer(t *testing.T, delay time.Duration) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		if r.URL.Path != "/restapi/oauth/token" {
+			http.NotFound(w, r)
+			return
+		}
+		if _, _, ok := r.BasicAuth(); !ok || r.PostFormValue("grant_type") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"at","token_type":"bearer","expires_in":3600,"refresh_token":"rt"}`)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRetrieveTokenDeadline(t *testing.T) {
+	tests := []struct {
+		delay   time.Duration
+		timeout time.Duration
+		wantErr bool
+	}{
+		{0, time.Second, false},
+		{time.Second, 50 * time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		server := newTokenServer(t, tt.delay)
+		app := ro.ApplicationCredentials{
+			ServerURL:    server.URL,
+			ClientID:     "id",
+			ClientSecret: "secret"}
+		ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+		start := time.Now()
+		token, err := RetrieveToken(ctx, app.Config(), url.Values{"grant_type": {"password"}})
+		cancel()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("RetrieveToken delay [%v] timeout [%v]: want error", tt.delay, tt.timeout)
+			} else if elapsed := time.Since(start); elapsed >= tt.delay {
+				t.Errorf("RetrieveToken delay [%v] timeout [%v]: returned after [%v]", tt.delay, tt.timeout, elapsed)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("RetrieveToken: %v", err)
+		} else if token.AccessToken != "at" || token.RefreshToken != "rt" {
+			t.Errorf("RetrieveToken: want [at rt], got [%v %v]", token.AccessToken, token.RefreshToken)
+		}
+	}
+}
+
+func TestTokenCacheSerializesGrants(t *testing.T) {
+	grants := int32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Refresh tokens rotate so only the first use succeeds.
+		if r.PostFormValue("refresh_token") != "rt1" || atomic.AddInt32(&grants, 1) > 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"at","token_type":"bearer","expires_in":3600,"refresh_token":"rt2"}`)
+	}))
+	defer server.Close()
+
+	cache, err := NewTokenCache(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := ro.ApplicationCredentials{ServerURL: server.URL, ClientID: "id", ClientSecret: "secret"}
+	params := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {"rt1"}}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.GrantAPIClient(context.Background(), app, "apikey:1", params, nil); err != nil {
+				t.Errorf("TokenCache.GrantAPIClient: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := atomic.LoadInt32(&grants); got != 1 {
+		t.Errorf("token requests: want [1], got [%v]", got)
+	}
+	if cache.Len() != 1 || len(cache.grants) != 0 {
+		t.Errorf("want [1] entry and [0] grant locks, got [%v] and [%v]", cache.Len(), len(cache.grants))
+	}
+}
+
+func TestTokenCacheTokensUserFilter(t *testing.T) {
+	cache, err := NewTokenCache(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := ro.ApplicationCredentials{ServerURL: "https://platform.example.com", ClientID: "id"}
+	for _, user := range []string{"+16505550100", "+16505550100*101", "+16505550199", "apikey:abc", "frontdesk@example.com"} {
+		cache.add(&tokenCacheEntry{
+			key:    cache.key(app, user),
+			user:   user,
+			app:    app,
+			source: &refreshTokenSource{token: &oauth2.Token{Expiry: time.Now().Add(time.Hour)}}})
+	}
+	tests := []struct {
+		user string
+		want int
+	}{
+		{"", 5},
+		{"16505550100", 2},
+		{"(650) 555-0100", 2},
+		{"+16505550100*101", 1},
+		{"16505550100*102", 0},
+		{"apikey:abc", 1},
+		{"apikey:abd", 0},
+		{"frontdesk@example.com", 1},
+	}
+	for _, tt := range tests {
+		if got := len(cache.Tokens(tt.user)); got != tt.want {
+			t.Errorf("TokenCache.Tokens(%q): want [%v], got [%v]", tt.user, tt.want, got)
+		}
+	}
+}
@@ -1,13 +1,14 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
-	"strconv"
 	"strings"
+	"time"
 
-	cfg "github.com/grokify/gotilla/config"
 	log "github.com/sirupsen/logrus"
 
 	rc "github.com/grokify/go-ringcentral/client"
@@ -15,7 +16,6 @@ import (
 	ro "github.com/grokify/oauth2more/ringcentral"
 
 	"github.com/apex/gateway"
-	"github.com/buaazp/fasthttprouter"
 	"github.com/grokify/gotilla/net/anyhttp"
 	"github.com/grokify/ringcentral-legacy-api-proxy/handlers"
 	"github.com/valyala/fasthttp"
@@ -23,64 +23,211 @@ import (
 
 // Handler is a struct to hold the service handlers.
 type Handler struct {
-	AppPort        int
-	APIClient      *rc.APIClient
-	AppCredentials *ro.ApplicationCredentials
+	AppPort             int
+	APIClient           *rc.APIClient
+	Apps                *handlers.AppRegistry
+	APIKeys             *handlers.APIKeyStore
+	Sessions            *handlers.SessionStore
+	TokenCache          *handlers.TokenCache
+	FaxTracker          *handlers.FaxTracker
+	Idempotency         *handlers.IdempotencyStore
+	Timeouts            handlers.UpstreamTimeouts
+	RateLimiter         *handlers.RateLimiter
+	Metrics             *handlers.Metrics
+	MetricsPort         int
+	Health              *handlers.HealthChecker
+	AdminPort           int
+	AdminAuth           handlers.AdminAuth
+	ShutdownGracePeriod time.Duration
 }
 
-func (h *Handler) FaxOutNetHttp(res http.ResponseWriter, req *http.Request) {
-	log.Info("START_HANDLE_FAXOUT_NET_HTTP")
-	h.handleAnyRequestFaxOut(anyhttp.NewResReqNetHttp(res, req))
+// selectApp returns the RingCentral app for the request and writes
+// a `400` if no app matches.
+func (h *Handler) selectApp(aRes anyhttp.Response, aReq anyhttp.Request, username, responseFormat string) (*handlers.App, bool) {
+	app, err := h.Apps.Select(aReq, username)
+	if err != nil {
+		handlers.WriteLegacyErrorAnyResponse(aRes, http.StatusBadRequest, responseFormat, "AppNotFound", err)
+		return nil, false
+	}
+	handlers.RequestLogFromContext(handlers.RequestContext(aReq)).SetApp(app.Name)
+	return app, true
 }
 
-func (h *Handler) FaxOutFastHttp(ctx *fasthttp.RequestCtx) {
-	log.Info("START_HANDLE_FAXOUT_FAST_HTTP")
-	h.handleAnyRequestFaxOut(anyhttp.NewResReqFastHttp(ctx))
-}
+// authorize returns the app and an authorized API client for the user
+// credentials. A proxy API key in `password` or the API key header is
+// resolved to its stored credentials and app. `onError` writes the
+// response for invalid credentials. `ok` is false if a response has
+// been written.
+func (h *Handler) authorize(ctx context.Context, aRes anyhttp.Response, aReq anyhttp.Request, pwdCreds ro.PasswordCredentials, responseFormat string, onError func(error)) (*handlers.App, *rc.APIClient, bool) {
+	key := h.APIKeys.RequestAPIKey(aReq, pwdCreds.Password)
+	if len(key) == 0 {
+		if h.APIKeys.IsRequired() {
+			onError(fmt.Errorf("an API key is required"))
+			return nil, nil, false
+		}
+		app, ok := h.selectApp(aRes, aReq, pwdCreds.Username, responseFormat)
+		if !ok {
+			return nil, nil, false
+		}
+		apiClient, err := h.TokenCache.APIClient(ctx, app.Credentials, pwdCreds)
+		if err != nil {
+			onError(err)
+			return nil, nil, false
+		}
+		return app, apiClient, true
+	}
 
-func (h *Handler) RingOutNetHttp(res http.ResponseWriter, req *http.Request) {
-	log.Info("START_HANDLE_RINGOUT_NET_HTTP")
-	h.handleAnyRequestRingOut(anyhttp.NewResReqNetHttp(res, req))
-}
+	apiKey, ok := h.APIKeys.Lookup(key)
+	if !ok {
+		onError(fmt.Errorf("invalid API key"))
+		return nil, nil, false
+	}
+	reqLog := handlers.RequestLogFromContext(handlers.RequestContext(aReq))
+	reqLog.SetAPIKeyID(apiKey.ID)
 
-func (h *Handler) RingOutFastHttp(ctx *fasthttp.RequestCtx) {
-	log.Info("START_HANDLE_RINGOUT_FAST_HTTP")
-	h.handleAnyRequestRingOut(anyhttp.NewResReqFastHttp(ctx))
+	var app *handlers.App
+	if len(apiKey.App) > 0 {
+		if app, ok = h.Apps.Get(apiKey.App); !ok {
+			onError(fmt.Errorf("app [%v] for API key is not configured", apiKey.App))
+			return nil, nil, false
+		}
+		reqLog.SetApp(app.Name)
+	} else if app, ok = h.selectApp(aRes, aReq, apiKey.Credentials.Username, responseFormat); !ok {
+		return nil, nil, false
+	}
+	apiClient, err := h.APIKeys.APIClient(ctx, h.TokenCache, app.Credentials, apiKey)
+	if err != nil {
+		onError(err)
+		return nil, nil, false
+	}
+	return app, apiClient, true
 }
 
-func (h *Handler) handleAnyRequestFaxOut(aRes anyhttp.Response, aReq anyhttp.Request) {
-	log.Info("START_HANDLE_FAXOUT_ANY_REQUEST")
-	if strings.ToUpper(string(aReq.Method())) != http.MethodPost {
-		anyhttp.WriteSimpleJson(aRes,
-			http.StatusMethodNotAllowed,
-			fmt.Sprintf("Method [%v] not allowed", string(aReq.Method())))
-		return
+// allowRequest checks the proxy rate limits for the command and writes
+// a `429` if the request is over a limit. Requests with an API key are
+// limited per API key.
+func (h *Handler) allowRequest(aRes anyhttp.Response, aReq anyhttp.Request, cmd string, pwdCreds ro.PasswordCredentials, responseFormat string) bool {
+	if h.RateLimiter == nil {
+		return true
+	}
+	user := strings.TrimSpace(pwdCreds.Username)
+	if ext := strings.TrimSpace(pwdCreds.Extension); len(ext) > 0 {
+		user += "*" + ext
+	}
+	if id := handlers.APIKeyID(h.APIKeys.RequestAPIKey(aReq, pwdCreds.Password)); len(id) > 0 {
+		user = "apikey:" + id
 	}
+	ok, wait := h.RateLimiter.Allow(cmd, user, handlers.ClientIP(aReq, h.RateLimiter.TrustForwardedFor))
+	if !ok {
+		handlers.RequestLogFromContext(handlers.RequestContext(aReq)).Entry().WithFields(log.Fields{
+			"action": "proxy_rate_limited"}).Warn("Request over proxy rate limit.")
+		handlers.WriteRetryAfterAnyResponse(aRes, responseFormat, handlers.RetryAfterSecondsDuration(wait))
+	}
+	return ok
+}
 
+func (h *Handler) handleAnyRequestFaxOut(aRes anyhttp.Response, aReq anyhttp.Request) {
 	form, err := aReq.MultipartForm()
 	if err != nil {
-		anyhttp.WriteSimpleJson(aRes, http.StatusBadRequest, err.Error())
+		handlers.WriteErrorJson(aRes, http.StatusBadRequest, err)
 		return
 	}
 	formParser := handlers.NewLegacyMultipartFormParser(form)
 
+	restFaxReq := formParser.FaxRequest()
+	if code := handlers.ValidateFaxRequest(restFaxReq); code != handlers.Successful {
+		handlers.WriteFaxResponseCode(aRes, code, formParser.Format())
+		return
+	}
+
 	pwdCreds := formParser.PasswordCredentials()
-	pwdCreds.RefreshTokenTTL = int64(-1)
+	if !h.allowRequest(aRes, aReq, "faxout", pwdCreds, formParser.Format()) {
+		return
+	}
+
+	ctx, cancel := h.Timeouts.WithTimeout(aReq, "faxout")
+	defer cancel()
 
 	// Authorize
-	apiClient, err := ru.NewApiClientPassword(*h.AppCredentials, pwdCreds)
-	if err != nil {
-		anyhttp.WriteSimpleJson(aRes, http.StatusUnauthorized, err.Error())
+	app, apiClient, ok := h.authorize(ctx, aRes, aReq, pwdCreds, formParser.Format(), func(err error) {
+		handlers.WriteErrorJson(aRes, http.StatusUnauthorized, err)
+	})
+	if !ok {
 		return
 	}
 
-	restFaxReq := formParser.FaxRequest()
+	// Retries of the same fax replay the first response.
+	idempotencyKey := h.Idempotency.RequestKey(aReq, "faxout",
+		handlers.NewLegacyArgsMultipartForm(form),
+		handlers.MultipartFormFiles(form, "Attachment"))
+
+	h.Idempotency.Do(ctx, aRes, idempotencyKey, formParser.Format(), func(aRes anyhttp.Response) {
+		resp, err := restFaxReq.Post(
+			handlers.HTTPClientWithContext(ctx, apiClient.HTTPClient()),
+			ru.BuildFaxApiUrl(app.Credentials.ServerURL))
+
+		if err == nil && resp.StatusCode < 300 && h.FaxTracker != nil {
+			if messageID, err := handlers.FaxResponseMessageID(resp); err == nil {
+				if err := h.FaxTracker.Track(apiClient, app.Credentials.ServerURL, messageID); err != nil {
+					log.Warnf("Fax status not tracked: %v", err)
+				}
+			} else {
+				log.Warnf("Fax status not tracked: %v", err)
+			}
+		}
+
+		handlers.WriteFaxAnyResponse(ctx, aRes, resp, err, formParser.Format())
+	})
+}
+
+// handleAnyRequestFaxStatus returns the delivery status of a fax
+// sent with `faxout.asp`. Parameters can be multipart form parameters
+// like `faxout.asp` or query string / URL encoded form parameters.
+func (h *Handler) handleAnyRequestFaxStatus(aRes anyhttp.Response, aReq anyhttp.Request) {
+	var reqParams handlers.FaxStatusRequestParams
+	if form, err := aReq.MultipartForm(); err == nil && form != nil {
+		reqParams = handlers.NewFaxStatusRequestParamsFromMultipartForm(form)
+	} else {
+		if err := aReq.ParseForm(); err != nil {
+			handlers.WriteErrorJson(aRes, http.StatusBadRequest, err)
+			return
+		}
+		reqParams = handlers.NewFaxStatusRequestParamsFromAnyArgs(aReq.AllArgs())
+	}
+
+	pwdCreds := reqParams.PasswordCredentials()
+	if !h.allowRequest(aRes, aReq, "faxstatus", pwdCreds, reqParams.Format) {
+		return
+	}
+
+	ctx, cancel := h.Timeouts.WithTimeout(aReq, "faxstatus")
+	defer cancel()
+
+	// Authorize
+	app, apiClient, ok := h.authorize(ctx, aRes, aReq, pwdCreds, reqParams.Format, func(err error) {
+		handlers.WriteFaxResponseCode(aRes, handlers.AuthorizationFailed, reqParams.Format)
+	})
+	if !ok {
+		return
+	}
 
-	resp, err := restFaxReq.Post(
-		apiClient.HTTPClient(),
-		ru.BuildFaxApiUrl(os.Getenv("RINGCENTRAL_SERVER_URL")))
+	handlers.FaxStatusAnyResponse(ctx, aRes, apiClient, app.Credentials.ServerURL, reqParams)
+}
 
-	handlers.WriteFaxAnyResponse(aRes, resp, err, formParser.Format())
+func (h *Handler) handleAnyRequestHealthz(aRes anyhttp.Response, aReq anyhttp.Request) {
+	h.Health.LivenessAnyResponse(aRes)
+}
+
+// handleAnyRequestReadyz checks the app credentials and, if enabled,
+// REST API reachability. `format=json` returns each check.
+func (h *Handler) handleAnyRequestReadyz(aRes anyhttp.Response, aReq anyhttp.Request) {
+	format := ""
+	if err := aReq.ParseForm(); err == nil {
+		format = strings.ToLower(handlers.NewLegacyArgs(aReq.AllArgs()).GetString("format"))
+	}
+	ctx, cancel := context.WithTimeout(handlers.RequestContext(aReq), handlers.DefaultUpstreamProbeTimeout)
+	defer cancel()
+	h.Health.ReadinessAnyResponse(ctx, aRes, format)
 }
 
 // RingOut is a net/http handler for performing a RingOut API
@@ -90,7 +237,7 @@ func (h *Handler) handleAnyRequestRingOut(aRes anyhttp.Response, aReq anyhttp.Re
 
 	aReq.Method()
 	if err != nil {
-		anyhttp.WriteSimpleJson(aRes, http.StatusBadRequest, err.Error())
+		handlers.WriteErrorJson(aRes, http.StatusBadRequest, err)
 		return
 	}
 	reqParams := handlers.NewRingOutRequestParamsFromAnyArgs(aReq.AllArgs())
@@ -99,21 +246,54 @@ func (h *Handler) handleAnyRequestRingOut(aRes anyhttp.Response, aReq anyhttp.Re
 		return
 	}
 
-	// Authorize
-	apiClient, err := ru.NewApiClientPassword(
-		*h.AppCredentials,
-		ro.PasswordCredentials{
-			Username:        reqParams.Username,
-			Extension:       reqParams.Ext,
-			Password:        reqParams.Password,
-			RefreshTokenTTL: int64(-1)})
-	if err != nil {
-		aRes.SetStatusCode(http.StatusUnauthorized)
+	cmd := strings.ToLower(reqParams.Cmd)
+	handlers.RequestLogFromContext(handlers.RequestContext(aReq)).SetCommand(cmd)
+
+	pwdCreds := ro.PasswordCredentials{
+		Username:  reqParams.Username,
+		Extension: reqParams.Ext,
+		Password:  reqParams.Password}
+	if !h.allowRequest(aRes, aReq, cmd, pwdCreds, reqParams.Format) {
 		return
 	}
 
+	ctx, cancel := h.Timeouts.WithTimeout(aReq, cmd)
+	defer cancel()
+
+	// Authorize. `status` and `cancel` can use the session
+	// cookie set by `call` instead of user credentials.
+	var apiClient *rc.APIClient
+	if cmd == "status" || cmd == "cancel" {
+		sess, ok := h.Sessions.Get(handlers.GetCookie(aReq, handlers.SessionCookieName))
+		if ok && len(sess.APIKeyID) > 0 && !h.APIKeys.Has(sess.APIKeyID) {
+			// The API key was revoked, e.g. with the `apikey` command.
+			h.Sessions.Delete(sess.ID)
+			ok = false
+		}
+		if ok {
+			apiClient = sess.APIClient
+			if len(strings.TrimSpace(reqParams.SessionID)) == 0 {
+				reqParams.SessionID = handlers.EncodeSessionID(sess.RingOutID)
+			}
+			if len(strings.TrimSpace(reqParams.To)) == 0 {
+				reqParams.To = sess.To
+			}
+			if len(strings.TrimSpace(reqParams.From)) == 0 {
+				reqParams.From = sess.From
+			}
+		}
+	}
+	if apiClient == nil {
+		var ok bool
+		if _, apiClient, ok = h.authorize(ctx, aRes, aReq, pwdCreds, reqParams.Format, func(err error) {
+			aRes.SetStatusCode(http.StatusUnauthorized)
+		}); !ok {
+			return
+		}
+	}
+
 	// Process Request
-	switch strings.ToLower(reqParams.Cmd) {
+	switch cmd {
 	case "call":
 		ringOut := ru.RingOutRequest{
 			To:         reqParams.To,
@@ -121,77 +301,178 @@ func (h *Handler) handleAnyRequestRingOut(aRes anyhttp.Response, aReq anyhttp.Re
 			CallerId:   reqParams.Clid,
 			PlayPrompt: reqParams.PlayPrompt()}
 
-		log.Printf("%v\n", ringOut)
-		handlers.RingoutCallAnyResponse(aRes, apiClient, ringOut, reqParams.Format)
+		handlers.RequestLogFromContext(ctx).Entry().WithFields(log.Fields{
+			"action":   "ringout_call",
+			"to":       ringOut.To,
+			"from":     ringOut.From,
+			"callerId": ringOut.CallerId}).Info("RINGOUT_CALL")
+
+		// Retries of the same call replay the first response.
+		idempotencyKey := h.Idempotency.RequestKey(aReq, "ringout",
+			handlers.NewLegacyArgs(aReq.AllArgs()), nil)
+
+		// Sessions record the API key so revoking it deletes them.
+		apiKeyID := handlers.APIKeyID(h.APIKeys.RequestAPIKey(aReq, pwdCreds.Password))
+		h.Idempotency.Do(ctx, aRes, idempotencyKey, reqParams.Format, func(aRes anyhttp.Response) {
+			handlers.RingoutCallAnyResponse(ctx, aRes, apiClient, apiKeyID, ringOut, reqParams.Format, h.Sessions)
+		})
 	case "list":
-		handlers.RingoutListAnyResponse(aRes, apiClient, reqParams.Format)
+		handlers.RingoutListAnyResponse(ctx, aRes, apiClient, reqParams.Format)
+	case "status":
+		handlers.RingoutStatusAnyResponse(ctx, aRes, apiClient, reqParams)
+	case "cancel":
+		handlers.RingoutCancelAnyResponse(ctx, aRes, apiClient, reqParams, h.Sessions)
 	}
 }
 
 func serveAwsLambda(handler Handler) {
 	log.Info("STARTING_AWS_LAMBDA")
-	log.Fatal(gateway.ListenAndServe(fmt.Sprintf(":%v", handler.AppPort), getHttpServeMux(handler)))
+	log.Fatal(gateway.ListenAndServe(fmt.Sprintf(":%v", handler.AppPort), getHttpServeMux(handler, "awslambda", nil)))
 }
 
 func serveNetHttp(handler Handler) {
 	log.Info("STARTING_NET_HTTP")
-	done := make(chan bool)
-	go http.ListenAndServe(fmt.Sprintf(":%v", handler.AppPort), getHttpServeMux(handler))
-	log.Printf("Server listening on port %v", handler.AppPort)
-	<-done
+	lifecycle := NewLifecycle(handler.ShutdownGracePeriod)
+	if handler.FaxTracker != nil {
+		lifecycle.OnShutdown("fax tracker", handler.FaxTracker.Shutdown)
+	}
+	lifecycle.Add("main", fmt.Sprintf(":%v", handler.AppPort), &http.Server{
+		Handler: getHttpServeMux(handler, "nethttp", lifecycle.Tracker)})
+	if handler.Metrics != nil && handler.MetricsPort > 0 {
+		lifecycle.Add("metrics", fmt.Sprintf(":%v", handler.MetricsPort), &http.Server{
+			Handler: NewRouter("nethttp", handler.MetricsRoutes()...)})
+	}
+	if handler.AdminPort > 0 {
+		lifecycle.Add("admin", fmt.Sprintf(":%v", handler.AdminPort), &http.Server{
+			Handler: getAdminServeMux(handler, "nethttp", lifecycle.Tracker)})
+	}
+	if err := lifecycle.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func getHttpServeMux(handler Handler, engine string, tracker *RequestTracker) *Router {
+	router := NewRouter(engine, handler.Routes()...)
+	router.Metrics = handler.Metrics
+	router.Tracker = tracker
+	router.PathPrefixes = handler.Apps.PathPrefixes()
+	return router
 }
 
-func getHttpServeMux(handler Handler) *http.ServeMux {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/ringout.asp", http.HandlerFunc(handler.RingOutNetHttp))
-	mux.HandleFunc("/ringout.asp/", http.HandlerFunc(handler.RingOutNetHttp))
-	mux.HandleFunc("/faxout.asp", http.HandlerFunc(handler.FaxOutNetHttp))
-	mux.HandleFunc("/faxout.asp/", http.HandlerFunc(handler.FaxOutNetHttp))
-	return mux
+// getAdminServeMux returns the admin API router. Admin requests are
+// not counted in the request metrics.
+func getAdminServeMux(handler Handler, engine string, tracker *RequestTracker) *Router {
+	router := NewRouter(engine, handler.AdminRoutes()...)
+	router.Tracker = tracker
+	return router
 }
 
 func serveFastHttp(handler Handler) {
 	log.Info("STARTING_FAST_HTTP")
-	router := fasthttprouter.New()
-	router.POST("/faxout.asp", handler.FaxOutFastHttp)
-	router.POST("/faxout.asp/", handler.FaxOutFastHttp)
-	router.POST("/ringout.asp", handler.RingOutFastHttp)
-	router.POST("/ringout.asp/", handler.RingOutFastHttp)
-	router.GET("/ringout.asp", handler.RingOutFastHttp)
-	router.GET("/ringout.asp/", handler.RingOutFastHttp)
-
-	done := make(chan bool)
-	go fasthttp.ListenAndServe(fmt.Sprintf(":%v", handler.AppPort), router.Handler)
-	log.Printf("Server listening on port %v", handler.AppPort)
-	<-done
+	lifecycle := NewLifecycle(handler.ShutdownGracePeriod)
+	if handler.FaxTracker != nil {
+		lifecycle.OnShutdown("fax tracker", handler.FaxTracker.Shutdown)
+	}
+	lifecycle.Add("main", fmt.Sprintf(":%v", handler.AppPort), &fastHttpServer{
+		Server: &fasthttp.Server{
+			Handler: getHttpServeMux(handler, "fasthttp", lifecycle.Tracker).HandleFastHttp}})
+	if handler.Metrics != nil && handler.MetricsPort > 0 {
+		lifecycle.Add("metrics", fmt.Sprintf(":%v", handler.MetricsPort), &fastHttpServer{
+			Server: &fasthttp.Server{
+				Handler: NewRouter("fasthttp", handler.MetricsRoutes()...).HandleFastHttp}})
+	}
+	if handler.AdminPort > 0 {
+		lifecycle.Add("admin", fmt.Sprintf(":%v", handler.AdminPort), &fastHttpServer{
+			Server: &fasthttp.Server{
+				Handler: getAdminServeMux(handler, "fasthttp", lifecycle.Tracker).HandleFastHttp}})
+	}
+	if err := lifecycle.Run(); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func main() {
-	err := cfg.LoadDotEnvSkipEmpty(os.Getenv("ENV_PATH"), "./.env")
-	if err != nil {
-		panic(err)
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "JSON or YAML configuration file")
+	checkConfig := flag.Bool("check-config", false, "print the configuration with secrets masked and exit")
+	flag.Parse()
+
+	config := LoadConfig(*configFile)
+	if *checkConfig {
+		config.Write(os.Stdout)
+		if err := config.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
 	}
+	if flag.Arg(0) == "apikey" {
+		if err := runAPIKeyCommand(config, flag.Args()[1:], os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Credentials are never logged and phone numbers are masked unless
+	// `LOG_MASK_PHONE_NUMBERS` is `false`.
+	log.SetFormatter(&handlers.RedactingFormatter{
+		Formatter:        &log.TextFormatter{},
+		MaskPhoneNumbers: config.LogMaskPhoneNumbers})
 
-	// PORT environment variable is automatically set for Heroku.
-	portRaw := os.Getenv("PORT")
-	port, err := strconv.Atoi(portRaw)
+	if err := config.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	tokenCache, err := handlers.NewTokenCache(handlers.DefaultTokenCacheSize)
 	if err != nil {
-		port = 3000
+		panic(err)
 	}
+	metrics := handlers.NewMetrics()
+	tokenCache.Metrics = metrics
 
 	handler := Handler{
-		AppPort: port,
-		AppCredentials: &ro.ApplicationCredentials{
-			ServerURL:    os.Getenv("RINGCENTRAL_SERVER_URL"),
-			ClientID:     os.Getenv("RINGCENTRAL_CLIENT_ID"),
-			ClientSecret: os.Getenv("RINGCENTRAL_CLIENT_SECRET")}}
+		AppPort:             config.Port,
+		Apps:                config.Apps,
+		APIKeys:             config.APIKeys,
+		Sessions:            handlers.NewSessionStore(handlers.DefaultSessionTTL),
+		TokenCache:          tokenCache,
+		Timeouts:            config.UpstreamTimeouts,
+		RateLimiter:         config.RateLimiter,
+		Metrics:             metrics,
+		MetricsPort:         config.MetricsPort,
+		AdminPort:           config.AdminPort,
+		AdminAuth:           handlers.AdminAuth{Token: config.AdminToken},
+		ShutdownGracePeriod: config.ShutdownGracePeriod}
+
+	// Fax status emails are sent if an SMTP relay is configured.
+	if len(config.SMTP.Addr) > 0 {
+		mailer := config.SMTP
+		handler.FaxTracker = handlers.NewFaxTracker(&mailer)
+	}
+
+	// Readiness probes the REST API if `READYZ_PROBE_UPSTREAM` is `true`.
+	handler.Health = &handlers.HealthChecker{Apps: config.Apps}
+	if config.ReadyzProbeUpstream {
+		handler.Health.Probes = map[string]*handlers.UpstreamProbe{}
+		for _, app := range config.Apps.Apps() {
+			handler.Health.Probes[app.Name] = handlers.NewUpstreamProbe(app.Credentials.ServerURL, config.ReadyzProbeInterval)
+		}
+	}
 
-	engine := strings.ToLower(strings.TrimSpace(os.Getenv("HTTP_ENGINE")))
-	if len(engine) == 0 {
-		engine = "nethttp"
+	// Duplicate RingOut calls and faxes are replayed within the window
+	// unless `IDEMPOTENCY_WINDOW` is `0`.
+	if config.IdempotencyWindow > 0 {
+		handler.Idempotency, err = handlers.NewIdempotencyStore(config.IdempotencyWindow, config.IdempotencyFingerprint)
+		if err != nil {
+			panic(err)
+		}
+		handler.Idempotency.FingerprintWindow = config.IdempotencyFingerprintWindow
+		if config.APIKeys != nil {
+			handler.Idempotency.ScopeHeaders = []string{config.APIKeys.Header}
+		}
 	}
 
-	switch engine {
+	switch config.HTTPEngine {
 	case "awslambda":
 		serveAwsLambda(handler)
 	case "fasthttp":
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+	ro "github.com/grokify/oauth2more/ringcentral"
+
+	"github.com/grokify/ringcentral-legacy-api-proxy/handlers"
+)
+
+// newUpstreamServer returns a REST API stand-in which counts requests.
+func newUpstreamServer(t *testing.T) (*httptest.Server, *int32) {
+	calls := new(int32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/restapi/oauth/token" {
+			fmt.Fprint(w, `{"access_token":"at","token_type":"bearer","expires_in":3600,"refresh_token":"rt"}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":1234,"messageStatus":"Queued"}`)
+	}))
+	t.Cleanup(server.Close)
+	return server, calls
+}
+
+func newTestHandler(t *testing.T, serverURL string) *Handler {
+	apps := handlers.NewAppRegistry()
+	apps.Add(&handlers.App{Name: handlers.DefaultAppName, Credentials: ro.ApplicationCredentials{
+		ServerURL: serverURL, ClientID: "id", ClientSecret: "secret"}})
+	cache, err := handlers.NewTokenCache(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Handler{
+		Apps:       apps,
+		TokenCache: cache,
+		Timeouts:   handlers.NewUpstreamTimeouts()}
+}
+
+// newFaxOutRequest returns a `faxout.asp` request with the form fields
+// and an `Attachment` file if `attachment` is set.
+func newFaxOutRequest(t *testing.T, fields map[string]string, attachment string) *http.Request {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	for name, value := range fields {
+		w.WriteField(name, value)
+	}
+	if len(attachment) > 0 {
+		part, err := w.CreateFormFile("Attachment", "fax.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(attachment))
+	}
+	w.Close()
+	req := httptest.NewRequest(http.MethodPost, "/faxout.asp", body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req
+}
+
+func TestFaxOutValidation(t *testing.T) {
+	tests := []struct {
+		recipient  string
+		attachment string
+		format     string
+		statusCode int
+		body       string
+		upstream   bool
+	}{
+		{"", "Hello", "", http.StatusBadRequest, "3", false},
+		{"", "Hello", "json", http.StatusBadRequest, "No recipients specified", false},
+		{" |Front Desk", "Hello", "", http.StatusBadRequest, "3", false},
+		{"16505550100|Front Desk", "", "", http.StatusBadRequest, "4", false},
+		{"16505550100|Front Desk", "", "json", http.StatusBadRequest, "No fax data specified", false},
+		{"16505550100|Front Desk", "Hello", "", http.StatusOK, "0", true},
+	}
+	for _, tt := range tests {
+		server, calls := newUpstreamServer(t)
+		h := newTestHandler(t, server.URL)
+		fields := map[string]string{"Username": "16505550101", "Password": "password", "Format": tt.format}
+		if len(tt.recipient) > 0 {
+			fields["Recipient"] = tt.recipient
+		}
+		rec := httptest.NewRecorder()
+		h.handleAnyRequestFaxOut(anyhttp.NewResReqNetHttp(rec, newFaxOutRequest(t, fields, tt.attachment)))
+		if rec.Code != tt.statusCode || !strings.Contains(rec.Body.String(), tt.body) {
+			t.Errorf("faxout Recipient [%v] attachment [%v] format [%v]: want [%v %v], got [%v %v]", tt.recipient,
+				tt.attachment, tt.format, tt.statusCode, tt.body, rec.Code, rec.Body.String())
+		}
+		if got := atomic.LoadInt32(calls) > 0; got != tt.upstream {
+			t.Errorf("faxout Recipient [%v] attachment [%v]: want upstream requests [%v], got [%v]",
+				tt.recipient, tt.attachment, tt.upstream, atomic.LoadInt32(calls))
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+	"github.com/grokify/ringcentral-legacy-api-proxy/handlers"
+	"github.com/valyala/fasthttp"
+)
+
+// AnyHandlerFunc handles a request for any HTTP engine.
+type AnyHandlerFunc func(aRes anyhttp.Response, aReq anyhttp.Request)
+
+// Route is an entry in the route table shared by all HTTP engines.
+type Route struct {
+	Name    string
+	Path    string
+	Methods []string
+	Handler AnyHandlerFunc
+}
+
+func (route *Route) allowsMethod(method string) bool {
+	for _, try := range route.Methods {
+		if strings.ToUpper(method) == try {
+			return true
+		}
+	}
+	return false
+}
+
+// Router dispatches `net/http`, `fasthttp` and AWS Lambda requests
+// using one route table so 404 and 405 behavior does not depend on
+// `HTTP_ENGINE`. Paths match with or without a trailing slash.
+type Router struct {
+	Engine  string
+	Metrics *handlers.Metrics
+	Tracker *RequestTracker
+	// PathPrefixes are app path prefixes, e.g. `/sandbox`, under
+	// which routes also match.
+	PathPrefixes []string
+	routes       map[string]Route
+}
+
+func NewRouter(engine string, routes ...Route) *Router {
+	router := &Router{Engine: engine, routes: map[string]Route{}}
+	for _, route := range routes {
+		router.routes[normalizeRoutePath(route.Path)] = route
+	}
+	return router
+}
+
+// Routes returns the route table for the legacy API endpoints.
+func (h *Handler) Routes() []Route {
+	routes := []Route{
+		{Name: "ringout", Path: "/ringout.asp",
+			Methods: []string{http.MethodGet, http.MethodPost},
+			Handler: h.handleAnyRequestRingOut},
+		{Name: "faxout", Path: "/faxout.asp",
+			Methods: []string{http.MethodPost},
+			Handler: h.handleAnyRequestFaxOut},
+		{Name: "faxstatus", Path: "/faxstatus.asp",
+			Methods: []string{http.MethodGet, http.MethodPost},
+			Handler: h.handleAnyRequestFaxStatus},
+	}
+	if h.Health != nil {
+		routes = append(routes,
+			Route{Name: "healthz", Path: "/healthz",
+				Methods: []string{http.MethodGet, http.MethodHead},
+				Handler: h.handleAnyRequestHealthz},
+			Route{Name: "readyz", Path: "/readyz",
+				Methods: []string{http.MethodGet, http.MethodHead},
+				Handler: h.handleAnyRequestReadyz})
+	}
+	if h.Metrics != nil && h.MetricsPort <= 0 {
+		routes = append(routes, h.MetricsRoutes()...)
+	}
+	return routes
+}
+
+// MetricsRoutes returns the route table for the metrics endpoint.
+func (h *Handler) MetricsRoutes() []Route {
+	return []Route{
+		{Name: "metrics", Path: "/metrics",
+			Methods: []string{http.MethodGet},
+			Handler: func(aRes anyhttp.Response, aReq anyhttp.Request) {
+				h.Metrics.WriteAnyResponse(aRes)
+			}},
+	}
+}
+
+// ServeHTTP implements `http.Handler` for `net/http` and AWS Lambda.
+func (router *Router) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	reqLog := handlers.NewRequestLog(req.Header.Get(handlers.RequestIDHeader), router.Engine)
+	req = req.WithContext(handlers.WithRequestLog(req.Context(), reqLog))
+	aRes, aReq := anyhttp.NewResReqNetHttp(res, req)
+	router.handle(aRes, aReq, reqLog, req.URL.Path, req.Method)
+}
+
+// HandleFastHttp implements `fasthttp.RequestHandler`.
+func (router *Router) HandleFastHttp(ctx *fasthttp.RequestCtx) {
+	reqLog := handlers.NewRequestLog(string(ctx.Request.Header.Peek(handlers.RequestIDHeader)), router.Engine)
+	ctx.SetUserValue(handlers.RequestLogUserValue, reqLog)
+	aRes, aReq := anyhttp.NewResReqFastHttp(ctx)
+	router.handle(aRes, aReq, reqLog, string(ctx.Path()), string(ctx.Method()))
+	if router.Tracker.Draining() {
+		// `net/http` closes idle connections on shutdown but the
+		// vendored `fasthttp` does not.
+		ctx.SetConnectionClose()
+	}
+}
+
+// handle dispatches a request. The request ID is returned in the
+// `X-Request-Id` response header and logged with each log line.
+func (router *Router) handle(aRes anyhttp.Response, aReq anyhttp.Request, reqLog *handlers.RequestLog, path, method string) {
+	handlers.SetHeader(aRes, handlers.RequestIDHeader, reqLog.ID)
+	aRes = reqLog.Response(aRes)
+	router.Tracker.Start()
+	defer router.Tracker.Done()
+	router.Metrics.RequestStarted()
+	defer func() {
+		reqLog.LogEnd()
+		router.Metrics.RequestFinished(reqLog.Result())
+	}()
+
+	route, ok := router.route(path)
+	if !ok {
+		handlers.WriteLegacyErrorAnyResponse(aRes, http.StatusNotFound, "", "NotFound",
+			fmt.Errorf("Path [%v] not found", path))
+		return
+	}
+	reqLog.SetRoute(route.Name)
+	if !route.allowsMethod(method) {
+		handlers.SetHeader(aRes, "Allow", strings.Join(route.Methods, ", "))
+		handlers.WriteLegacyErrorAnyResponse(aRes, http.StatusMethodNotAllowed, "", "MethodNotAllowed",
+			fmt.Errorf("Method [%v] not allowed", method))
+		return
+	}
+	reqLog.Entry().Info("START_HANDLE")
+	route.Handler(aRes, aReq)
+}
+
+// route returns the route for a path with or without an app path prefix.
+func (router *Router) route(path string) (Route, bool) {
+	if route, ok := router.routes[normalizeRoutePath(path)]; ok {
+		return route, true
+	}
+	for _, prefix := range router.PathPrefixes {
+		if strings.HasPrefix(path, prefix+"/") {
+			if route, ok := router.routes[normalizeRoutePath(strings.TrimPrefix(path, prefix))]; ok {
+				return route, true
+			}
+		}
+	}
+	return Route{}, false
+}
+
+func normalizeRoutePath(path string) string {
+	path = strings.TrimRight(strings.TrimSpace(path), "/")
+	if len(path) == 0 {
+		return "/"
+	}
+	return path
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grokify/gotilla/net/anyhttp"
+	"github.com/valyala/fasthttp"
+)
+
+func newTestRouter(engine string) *Router {
+	ok := func(aRes anyhttp.Response, aReq anyhttp.Request) {
+		aRes.SetStatusCode(http.StatusOK)
+		aRes.SetBodyBytes([]byte("OK"))
+	}
+	router := NewRouter(engine,
+		Route{Name: "ringout", Path: "/ringout.asp",
+			Methods: []string{http.MethodGet, http.MethodPost}, Handler: ok},
+		Route{Name: "faxout", Path: "/faxout.asp/",
+			Methods: []string{http.MethodPost}, Handler: ok})
+	router.PathPrefixes = []string{"/sandbox"}
+	return router
+}
+
+// routerResponse is a response from one of the HTTP engines.
+type routerResponse struct {
+	statusCode int
+	allow      string
+	body       string
+}
+
+func routeNetHttp(router *Router, method, path string) routerResponse {
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	return routerResponse{rec.Code, rec.Header().Get("Allow"), rec.Body.String()}
+}
+
+func routeFastHttp(router *Router, method, path string) routerResponse {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod(method)
+	ctx.Request.SetRequestURI(path)
+	router.HandleFastHttp(ctx)
+	return routerResponse{ctx.Response.StatusCode(),
+		string(ctx.Response.Header.Peek("Allow")), string(ctx.Response.Body())}
+}
+
+func TestRouter(t *testing.T) {
+	tests := []struct {
+		method     string
+		path       string
+		statusCode int
+		allow      string
+		body       string
+	}{
+		{http.MethodGet, "/ringout.asp", http.StatusOK, "", "OK"},
+		{http.MethodPost, "/ringout.asp/", http.StatusOK, "", "OK"},
+		{http.MethodPost, "/faxout.asp", http.StatusOK, "", "OK"},
+		{http.MethodPost, "/sandbox/faxout.asp", http.StatusOK, "", "OK"},
+		{http.MethodGet, "/faxout.asp", http.StatusMethodNotAllowed, "POST", "MethodNotAllowed"},
+		{http.MethodDelete, "/ringout.asp", http.StatusMethodNotAllowed, "GET, POST", "MethodNotAllowed"},
+		{http.MethodGet, "/missing.asp", http.StatusNotFound, "", "NotFound"},
+		{http.MethodGet, "/", http.StatusNotFound, "", "NotFound"},
+		{http.MethodGet, "/production/ringout.asp", http.StatusNotFound, "", "NotFound"},
+		{http.MethodGet, "/sandboxringout.asp", http.StatusNotFound, "", "NotFound"},
+	}
+	engines := map[string]func(*Router, string, string) routerResponse{
+		"nethttp":  routeNetHttp,
+		"fasthttp": routeFastHttp}
+	for engine, serve := range engines {
+		router := newTestRouter(engine)
+		for _, tt := range tests {
+			res := serve(router, tt.method, tt.path)
+			if res.statusCode != tt.statusCode || res.allow != tt.allow || !strings.Contains(res.body, tt.body) {
+				t.Errorf("%v %v %v: want [%v] Allow [%v] body [%v], got [%v] Allow [%v] body [%v]",
+					engine, tt.method, tt.path, tt.statusCode, tt.allow, tt.body,
+					res.statusCode, res.allow, res.body)
+			}
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/valyala/fasthttp"
+)
+
+// DefaultShutdownGracePeriod is under the 30 seconds Heroku and
+// Kubernetes wait after `SIGTERM` before killing the process.
+const DefaultShutdownGracePeriod = 25 * time.Second
+
+// Server is an HTTP engine server run by `Lifecycle`. `*http.Server`
+// implements it directly.
+type Server interface {
+	Serve(ln net.Listener) error
+	// Shutdown stops accepting requests. In-flight requests are
+	// waited for by `Lifecycle`.
+	Shutdown(ctx context.Context) error
+}
+
+// fastHttpServer adds `Shutdown` to `fasthttp.Server` by closing the
+// listener since the vendored `fasthttp` does not support shutdown.
+type fastHttpServer struct {
+	Server   *fasthttp.Server
+	mutex    sync.Mutex
+	listener net.Listener
+}
+
+func (srv *fastHttpServer) Serve(ln net.Listener) error {
+	srv.mutex.Lock()
+	srv.listener = ln
+	srv.mutex.Unlock()
+	return srv.Server.Serve(ln)
+}
+
+func (srv *fastHttpServer) Shutdown(ctx context.Context) error {
+	srv.mutex.Lock()
+	defer srv.mutex.Unlock()
+	if srv.listener == nil {
+		return nil
+	}
+	return srv.listener.Close()
+}
+
+// RequestTracker counts in-flight requests so they can be drained.
+type RequestTracker struct {
+	mutex    sync.Mutex
+	inFlight int
+	draining bool
+}
+
+func (tracker *RequestTracker) Start() {
+	if tracker == nil {
+		return
+	}
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.inFlight++
+}
+
+func (tracker *RequestTracker) Done() {
+	if tracker == nil {
+		return
+	}
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	tracker.inFlight--
+}
+
+func (tracker *RequestTracker) InFlight() int {
+	if tracker == nil {
+		return 0
+	}
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	return tracker.inFlight
+}
+
+// Draining returns true once shutdown has started so keep-alive
+// connections can be closed.
+func (tracker *RequestTracker) Draining() bool {
+	if tracker == nil {
+		return false
+	}
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	return tracker.draining
+}
+
+// Drain waits until there are no in-flight requests or the context ends.
+func (tracker *RequestTracker) Drain(ctx context.Context) error {
+	tracker.mutex.Lock()
+	tracker.draining = true
+	tracker.mutex.Unlock()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for tracker.InFlight() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Lifecycle runs servers for any HTTP engine. It exits with an error if
+// a listener fails and, on `SIGINT` or `SIGTERM`, stops accepting
+// requests and waits up to `GracePeriod` for in-flight requests.
+type Lifecycle struct {
+	GracePeriod time.Duration
+	Tracker     *RequestTracker
+	servers     []lifecycleServer
+	hooks       []lifecycleHook
+}
+
+type lifecycleHook struct {
+	name     string
+	shutdown func(ctx context.Context) error
+}
+
+type lifecycleServer struct {
+	name   string
+	addr   string
+	server Server
+}
+
+func NewLifecycle(gracePeriod time.Duration) *Lifecycle {
+	if gracePeriod <= 0 {
+		gracePeriod = DefaultShutdownGracePeriod
+	}
+	return &Lifecycle{
+		GracePeriod: gracePeriod,
+		Tracker:     &RequestTracker{}}
+}
+
+// Add adds a server to listen on `addr` when `Run` is called.
+func (lc *Lifecycle) Add(name, addr string, server Server) {
+	lc.servers = append(lc.servers, lifecycleServer{name: name, addr: addr, server: server})
+}
+
+// OnShutdown adds a function, such as stopping background work, which
+// is called after in-flight requests are drained and shares the grace
+// period.
+func (lc *Lifecycle) OnShutdown(name string, shutdown func(ctx context.Context) error) {
+	lc.hooks = append(lc.hooks, lifecycleHook{name: name, shutdown: shutdown})
+}
+
+// Run listens and serves until a signal is received or a server fails.
+func (lc *Lifecycle) Run() error {
+	listeners := []net.Listener{}
+	for _, srv := range lc.servers {
+		ln, err := net.Listen("tcp", srv.addr)
+		if err != nil {
+			for _, open := range listeners {
+				open.Close()
+			}
+			return fmt.Errorf("%v server listen on [%v] failed: %v", srv.name, srv.addr, err)
+		}
+		listeners = append(listeners, ln)
+	}
+
+	errs := make(chan error, len(lc.servers))
+	for i, srv := range lc.servers {
+		go func(srv lifecycleServer, ln net.Listener) {
+			errs <- fmt.Errorf("%v server failed: %v", srv.name, srv.server.Serve(ln))
+		}(srv, listeners[i])
+		log.Printf("%v server listening on %v", srv.name, srv.addr)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var runErr error
+	select {
+	case runErr = <-errs:
+		log.Error(runErr)
+	case sig := <-signals:
+		log.WithFields(log.Fields{
+			"signal":      sig.String(),
+			"inFlight":    lc.Tracker.InFlight(),
+			"gracePeriod": lc.GracePeriod.String()}).Info("SHUTDOWN_START")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lc.GracePeriod)
+	defer cancel()
+	for _, srv := range lc.servers {
+		if err := srv.server.Shutdown(ctx); err != nil {
+			log.Warnf("%v server shutdown: %v", srv.name, err)
+		}
+	}
+	drained := true
+	if err := lc.Tracker.Drain(ctx); err != nil {
+		drained = false
+		log.WithFields(log.Fields{
+			"inFlight": lc.Tracker.InFlight()}).Warn("SHUTDOWN_GRACE_PERIOD_EXCEEDED")
+	}
+	for _, hook := range lc.hooks {
+		if err := hook.shutdown(ctx); err != nil {
+			drained = false
+			log.Warnf("%v shutdown: %v", hook.name, err)
+		}
+	}
+	if drained {
+		log.Info("SHUTDOWN_COMPLETE")
+	}
+	return runErr
+}
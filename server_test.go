@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestRequestTrackerDrain(t *testing.T) {
+	tests := []struct {
+		inFlight int
+		doneIn   time.Duration
+		timeout  time.Duration
+		wantErr  bool
+	}{
+		{0, 0, 50 * time.Millisecond, false},
+		{2, 20 * time.Millisecond, time.Second, false},
+		{1, time.Second, 50 * time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		tracker := &RequestTracker{}
+		for i := 0; i < tt.inFlight; i++ {
+			tracker.Start()
+			time.AfterFunc(tt.doneIn, tracker.Done)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+		err := tracker.Drain(ctx)
+		cancel()
+		if (err != nil) != tt.wantErr || !tracker.Draining() {
+			t.Errorf("Drain with [%v] in flight: want error [%v], got [%v] draining [%v]",
+				tt.inFlight, tt.wantErr, err, tracker.Draining())
+		}
+	}
+	var tracker *RequestTracker
+	tracker.Start()
+	tracker.Done()
+	if tracker.InFlight() != 0 || tracker.Draining() {
+		t.Error("nil RequestTracker: want no requests")
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestLifecycleGracefulShutdown(t *testing.T) {
+	lc := NewLifecycle(time.Second)
+	started := make(chan bool)
+	netAddr := freeAddr(t)
+	lc.Add("net/http", netAddr, &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lc.Tracker.Start()
+		defer lc.Tracker.Done()
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("OK"))
+	})})
+	fastAddr := freeAddr(t)
+	lc.Add("fasthttp", fastAddr, &fastHttpServer{Server: &fasthttp.Server{
+		Handler: func(ctx *fasthttp.RequestCtx) { ctx.WriteString("OK") }}})
+	hookCalled := false
+	lc.OnShutdown("hook", func(ctx context.Context) error {
+		hookCalled = lc.Tracker.InFlight() == 0
+		return nil
+	})
+
+	runErr := make(chan error)
+	go func() { runErr <- lc.Run() }()
+	time.Sleep(50 * time.Millisecond)
+
+	body := make(chan string)
+	go func() {
+		resp, err := http.Get("http://" + netAddr)
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		bytes, _ := ioutil.ReadAll(resp.Body)
+		body <- string(bytes)
+	}()
+	<-started
+	syscall.Kill(os.Getpid(), syscall.SIGTERM)
+
+	if err := <-runErr; err != nil {
+		t.Errorf("Lifecycle.Run: want nil after SIGTERM, got [%v]", err)
+	}
+	if got := <-body; got != "OK" {
+		t.Errorf("in-flight request: want [OK], got [%v]", got)
+	}
+	if !hookCalled {
+		t.Error("shutdown hook not called after requests drained")
+	}
+	for _, addr := range []string{netAddr, fastAddr} {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			t.Errorf("listener [%v] still open after shutdown", addr)
+		}
+	}
+}
+
+func TestLifecycleListenError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lc := NewLifecycle(0)
+	if lc.GracePeriod != DefaultShutdownGracePeriod {
+		t.Errorf("NewLifecycle(0): want grace period [%v], got [%v]", DefaultShutdownGracePeriod, lc.GracePeriod)
+	}
+	addr := freeAddr(t)
+	lc.Add("free", addr, &http.Server{})
+	lc.Add("in use", ln.Addr().String(), &http.Server{})
+	if err := lc.Run(); err == nil {
+		t.Error("Lifecycle.Run with address in use: want error")
+	}
+	// The listener opened before the failure is closed.
+	check, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Errorf("listener [%v] not closed: %v", addr, err)
+	} else {
+		check.Close()
+	}
+}